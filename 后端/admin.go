@@ -0,0 +1,476 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 管理接口
+//
+// 运行中查看和修改阻塞列表、查看流量最大的来源、读取生效的配置和重新加载配置。
+// 接口没有认证, 只监听unix套接字(权限0600)和本机回环地址。
+
+// 通过管理接口手动阻塞
+const attackManual attackType = "manual"
+
+// 管理接口的默认unix套接字, blogguard命令使用相同的默认值
+const defaultAdminSocket = "/run/blogguard/blogguard.sock"
+
+const (
+	defaultTopSources = 20
+	maxTopSources     = 1000
+	maxAdminBody      = 4 << 10
+)
+
+var startedAt = time.Now()
+
+// 管理接口的通用响应, 出错时 message 为原因
+type adminResponse struct {
+	Status          string   `json:"status"`
+	Message         string   `json:"message,omitempty"`
+	RestartRequired []string `json:"restart_required,omitempty"` // 重新加载后需要重启才能生效的配置项
+}
+
+type adminStatus struct {
+	Status          string           `json:"status"`
+	StartedAt       time.Time        `json:"started_at"`
+	UptimeSeconds   float64          `json:"uptime_seconds"`
+	ConfigPath      string           `json:"config_path,omitempty"`
+	Interfaces      []adminInterface `json:"interfaces"`
+	Workers         int              `json:"workers"`
+	TrackedSources  int              `json:"tracked_sources"`
+	TrackedPrefixes int              `json:"tracked_prefixes"`
+	ActiveBlocks    int              `json:"active_blocks"`
+}
+
+type adminInterface struct {
+	Name      string `json:"name"`
+	Received  uint64 `json:"received"`
+	Dropped   uint64 `json:"dropped"`
+	IfDropped uint64 `json:"if_dropped"`
+}
+
+type adminBlock struct {
+	*blockEntry
+	Permanent        bool    `json:"permanent"`
+	RemainingSeconds float64 `json:"remaining_seconds"` // 永久阻塞为0
+}
+
+type adminBlockList struct {
+	Status string       `json:"status"`
+	Blocks []adminBlock `json:"blocks"`
+}
+
+// 手动阻塞请求, duration 为空时使用 block_duration
+type adminBlockRequest struct {
+	Target    string `json:"target"`
+	Duration  string `json:"duration"`
+	Permanent bool   `json:"permanent"`
+}
+
+type adminTopSource struct {
+	Source   string    `json:"source"`
+	Rate     float64   `json:"rate"` // 包/秒
+	LastSeen time.Time `json:"last_seen"`
+}
+
+type adminTop struct {
+	Status     string           `json:"status"`
+	Sources    []adminTopSource `json:"sources"`
+	Aggregates []heavyHitter    `json:"aggregates"` // 上一个聚合统计窗口的重流量键
+}
+
+type adminAllowlist struct {
+	Status     string   `json:"status"`
+	Configured []string `json:"configured"`
+	Runtime    []string `json:"runtime"` // 运行中添加的网段, 重启后失效
+}
+
+type adminTargetRequest struct {
+	Target string `json:"target"`
+}
+
+type adminServer struct {
+	args       []string // 启动参数, 重新加载配置时使用
+	configPath string
+}
+
+// 在后台启动管理接口, 套接字无法监听时返回错误
+func startAdminServer(c adminConfig, args []string, configPath string) error {
+	s := &adminServer{args: args, configPath: configPath}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.handleStatus)
+	mux.HandleFunc("/blocks", s.handleBlocks)
+	mux.HandleFunc("/top", s.handleTop)
+	mux.HandleFunc("/config", s.handleConfig)
+	mux.HandleFunc("/reload", s.handleReload)
+	mux.HandleFunc("/allowlist", s.handleAllowlist)
+
+	var listeners []net.Listener
+	if c.Socket != "" {
+		l, err := listenUnix(c.Socket)
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, l)
+	}
+	if c.Listen != "" {
+		l, err := net.Listen("tcp", c.Listen)
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, l)
+	}
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       10 * time.Second,
+		WriteTimeout:      10 * time.Second,
+	}
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			logEvent(slog.LevelInfo, "admin_started", "管理接口已启动",
+				slog.String("network", l.Addr().Network()), slog.String("listen", l.Addr().String()))
+			if err := server.Serve(l); err != nil && err != http.ErrServerClosed {
+				logEvent(slog.LevelError, "admin_error", "管理接口异常退出", slog.String("error", err.Error()))
+			}
+		}(l)
+	}
+	return nil
+}
+
+// 监听unix套接字, 上次运行遗留的套接字文件先删除
+func listenUnix(path string) (net.Listener, error) {
+	// /run下的目录在重启后不存在
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, err
+	}
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s 已存在且不是套接字", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// 地址是否只能从本机访问
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip, err := netip.ParseAddr(host)
+	return err == nil && ip.IsLoopback()
+}
+
+func allowMethods(w http.ResponseWriter, methods string) {
+	w.Header().Set("Allow", methods)
+	writeJSON(w, http.StatusMethodNotAllowed, adminResponse{Status: "error", Message: "只支持 " + methods + " 请求"})
+}
+
+func adminError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, adminResponse{Status: "error", Message: message})
+}
+
+func decodeAdminRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		adminError(w, http.StatusBadRequest, "请求格式错误")
+		return false
+	}
+	return true
+}
+
+// GET /status: 运行状态概览
+func (s *adminServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		allowMethods(w, "GET")
+		return
+	}
+
+	status := adminStatus{
+		Status:        "ok",
+		StartedAt:     startedAt,
+		UptimeSeconds: time.Since(startedAt).Seconds(),
+		ConfigPath:    s.configPath,
+		Interfaces:    []adminInterface{},
+		Workers:       len(engine.shards),
+	}
+	for name, t := range captureTotals() {
+		status.Interfaces = append(status.Interfaces, adminInterface{
+			Name: name, Received: t.received, Dropped: t.dropped, IfDropped: t.ifDropped,
+		})
+	}
+	slices.SortFunc(status.Interfaces, func(a, b adminInterface) int { return cmp.Compare(a.Name, b.Name) })
+	status.TrackedSources, status.TrackedPrefixes = engine.trackedCounts()
+	mu.RLock()
+	status.ActiveBlocks = len(blockedIPs)
+	mu.RUnlock()
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+// GET /blocks: 列出阻塞; POST /blocks: 手动阻塞; DELETE /blocks?target=: 手动解除阻塞
+func (s *adminServer) handleBlocks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.listBlocks(w)
+	case http.MethodPost:
+		s.block(w, r)
+	case http.MethodDelete:
+		s.unblock(w, r)
+	default:
+		allowMethods(w, "GET, POST, DELETE")
+	}
+}
+
+func (s *adminServer) listBlocks(w http.ResponseWriter) {
+	now := time.Now()
+	list := adminBlockList{Status: "ok", Blocks: []adminBlock{}}
+	mu.RLock()
+	for _, entry := range blockedIPs {
+		// 阻塞记录创建后不再修改, 可以在锁外编码
+		b := adminBlock{blockEntry: entry, Permanent: entry.Permanent()}
+		if !entry.Permanent() {
+			b.RemainingSeconds = max(entry.Remaining(now).Seconds(), 0)
+		}
+		list.Blocks = append(list.Blocks, b)
+	}
+	mu.RUnlock()
+
+	slices.SortFunc(list.Blocks, func(a, b adminBlock) int { return a.BlockedAt.Compare(b.BlockedAt) })
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *adminServer) block(w http.ResponseWriter, r *http.Request) {
+	var req adminBlockRequest
+	if !decodeAdminRequest(w, r, &req) {
+		return
+	}
+	cfg := currentConfig()
+	target, err := blockTarget(cfg, req.Target)
+	if err != nil {
+		adminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	duration := cfg.BlockDuration
+	switch {
+	case req.Permanent:
+		duration = 0
+	case req.Duration != "":
+		duration, err = time.ParseDuration(req.Duration)
+		if err != nil || duration <= 0 {
+			adminError(w, http.StatusBadRequest, fmt.Sprintf("无效的阻塞时间: %q", req.Duration))
+			return
+		}
+	}
+	if trusted.Overlaps(target) {
+		adminError(w, http.StatusConflict, fmt.Sprintf("%s 与可信来源重叠, 不能阻塞", target))
+		return
+	}
+
+	now := time.Now()
+	entry := &blockEntry{Target: target, Reason: attackManual, BlockedAt: now}
+	if duration > 0 {
+		entry.Expires = now.Add(duration)
+	}
+	mu.Lock()
+	if _, exists := blockedIPs[target]; exists {
+		unblockLocked(target, "replaced", now) // 按新的时间重新下发防火墙规则
+	}
+	addBlockLocked(entry, duration)
+	mu.Unlock()
+
+	writeJSON(w, http.StatusOK, adminBlockList{Status: "ok", Blocks: []adminBlock{{
+		blockEntry:       entry,
+		Permanent:        entry.Permanent(),
+		RemainingSeconds: duration.Seconds(),
+	}}})
+}
+
+// 手动解除阻塞通常是因为误报, 同时清除违规记录, 再次违规时从头计算阻塞时间
+func (s *adminServer) unblock(w http.ResponseWriter, r *http.Request) {
+	target, err := blockTarget(currentConfig(), r.URL.Query().Get("target"))
+	if err != nil {
+		adminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	mu.Lock()
+	_, exists := blockedIPs[target]
+	if exists {
+		unblockLocked(target, "manual", time.Now())
+		delete(offenses, target)
+	}
+	mu.Unlock()
+
+	if !exists {
+		adminError(w, http.StatusNotFound, fmt.Sprintf("%s 没有被阻塞", target))
+		return
+	}
+	writeJSON(w, http.StatusOK, adminResponse{Status: "ok"})
+}
+
+// 把IP或CIDR规范化成阻塞列表中的键: 单个地址与检测时使用的来源键相同,
+// IPv6地址因此对应它所在的 ipv6_source_prefix 网段
+func blockTarget(cfg *config, s string) (string, error) {
+	if s == "" {
+		return "", errors.New("缺少阻塞目标")
+	}
+	prefix, err := parsePrefix(s)
+	if err != nil {
+		return "", fmt.Errorf("无效的IP或CIDR %q", s)
+	}
+	if prefix.IsSingleIP() {
+		return sourceKey(cfg, prefix.Addr()), nil
+	}
+	return prefix.String(), nil
+}
+
+// GET /top?n=20: 当前速率最高的来源, 以及聚合统计中的重流量键
+func (s *adminServer) handleTop(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		allowMethods(w, "GET")
+		return
+	}
+	n := defaultTopSources
+	if v := r.URL.Query().Get("n"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 1 || parsed > maxTopSources {
+			adminError(w, http.StatusBadRequest, fmt.Sprintf("n 必须在1到%d之间", maxTopSources))
+			return
+		}
+		n = parsed
+	}
+
+	now := time.Now()
+	var sources []adminTopSource
+	engine.eachShard(func(s *shard) {
+		s.ipCounters.Each(func(source string, stats *ipStats, lastSeen time.Time) {
+			if rate := stats.packets.Rate(now); rate > 0 {
+				sources = append(sources, adminTopSource{Source: source, Rate: rate, LastSeen: lastSeen})
+			}
+		})
+	})
+	slices.SortFunc(sources, func(a, b adminTopSource) int { return cmp.Compare(b.Rate, a.Rate) })
+	if len(sources) > n {
+		sources = sources[:n]
+	}
+
+	top := adminTop{Status: "ok", Sources: []adminTopSource{}, Aggregates: []heavyHitter{}}
+	top.Sources = append(top.Sources, sources...)
+	top.Aggregates = append(top.Aggregates, aggregates.TopHitters()...)
+	writeJSON(w, http.StatusOK, top)
+}
+
+// GET /config: 当前生效的配置(包括命令行参数), 格式与配置文件相同, 不显示告警的密码和令牌
+func (s *adminServer) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		allowMethods(w, "GET")
+		return
+	}
+	c := *currentConfig()
+	c.Alerts = c.Alerts.redacted()
+	data, err := yaml.Marshal(&c)
+	if err != nil {
+		adminError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml; charset=utf-8")
+	w.Write(data)
+}
+
+// POST /reload: 与SIGHUP相同
+func (s *adminServer) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		allowMethods(w, "POST")
+		return
+	}
+	pending, err := reloadConfig(s.args)
+	if err != nil {
+		logEvent(slog.LevelError, "config_reload_failed", "重新加载配置失败, 继续使用旧配置", slog.String("error", err.Error()))
+		adminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, adminResponse{Status: "ok", RestartRequired: pending})
+}
+
+// GET /allowlist: 列出可信来源; POST /allowlist: 运行中添加; DELETE /allowlist?target=: 删除运行中添加的网段
+func (s *adminServer) handleAllowlist(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		configured, runtime := trusted.List()
+		list := adminAllowlist{Status: "ok", Configured: []string{}, Runtime: []string{}}
+		for _, p := range configured {
+			list.Configured = append(list.Configured, p.String())
+		}
+		for _, p := range runtime {
+			list.Runtime = append(list.Runtime, p.String())
+		}
+		writeJSON(w, http.StatusOK, list)
+
+	case http.MethodPost:
+		var req adminTargetRequest
+		if !decodeAdminRequest(w, r, &req) {
+			return
+		}
+		prefix, err := parsePrefix(req.Target)
+		if err != nil {
+			adminError(w, http.StatusBadRequest, fmt.Sprintf("无效的IP或CIDR %q", req.Target))
+			return
+		}
+		trusted.Add(prefix)
+		logEvent(slog.LevelInfo, "allowlist_added", "已添加可信来源", slog.String("target", prefix.String()))
+		// 新的可信来源如果正在被阻塞, 立即解除
+		mu.Lock()
+		releaseTrustedLocked(time.Now())
+		mu.Unlock()
+		writeJSON(w, http.StatusOK, adminResponse{Status: "ok"})
+
+	case http.MethodDelete:
+		prefix, err := parsePrefix(r.URL.Query().Get("target"))
+		if err != nil {
+			adminError(w, http.StatusBadRequest, "无效的IP或CIDR")
+			return
+		}
+		if !trusted.Remove(prefix) {
+			adminError(w, http.StatusNotFound, fmt.Sprintf("%s 不是运行中添加的可信来源", prefix))
+			return
+		}
+		logEvent(slog.LevelInfo, "allowlist_removed", "已删除可信来源", slog.String("target", prefix.String()))
+		writeJSON(w, http.StatusOK, adminResponse{Status: "ok"})
+
+	default:
+		allowMethods(w, "GET, POST, DELETE")
+	}
+}
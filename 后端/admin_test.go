@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 用新的阻塞状态和可信来源运行管理接口, 防火墙不做任何事
+func setupAdminTest(t *testing.T) (*adminServer, *config) {
+	t.Helper()
+	cfg := defaultConfig()
+	activeConfig.Store(cfg)
+
+	mu.Lock()
+	blockedIPs = make(map[string]*blockEntry)
+	blockedPrefixLens = make(map[prefixLen]int)
+	offenses = make(map[string]*offenseRecord)
+	mu.Unlock()
+	oldTrusted, oldFw := trusted, fw
+	trusted, fw = &allowlist{}, newFirewall(noopEnforcer{})
+
+	t.Cleanup(func() {
+		activeConfig.Store(defaultConfig())
+		trusted, fw = oldTrusted, oldFw
+		mu.Lock()
+		blockedIPs = make(map[string]*blockEntry)
+		blockedPrefixLens = make(map[prefixLen]int)
+		offenses = make(map[string]*offenseRecord)
+		mu.Unlock()
+	})
+	return &adminServer{}, cfg
+}
+
+// 调用处理函数并返回状态码和JSON响应
+func adminDo(t *testing.T, handler http.HandlerFunc, method, target, body string) (int, map[string]any) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+	var resp map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%s %s: 响应不是JSON: %s", method, target, rec.Body)
+	}
+	return rec.Code, resp
+}
+
+func blockedEntry(target string) *blockEntry {
+	mu.RLock()
+	defer mu.RUnlock()
+	return blockedIPs[target]
+}
+
+func TestAdminMethodNotAllowed(t *testing.T) {
+	s, _ := setupAdminTest(t)
+	for _, tc := range []struct {
+		handler http.HandlerFunc
+		method  string
+		path    string
+		allow   string
+	}{
+		{s.handleStatus, http.MethodPost, "/status", "GET"},
+		{s.handleBlocks, http.MethodPut, "/blocks", "GET, POST, DELETE"},
+		{s.handleTop, http.MethodDelete, "/top", "GET"},
+		{s.handleConfig, http.MethodPost, "/config", "GET"},
+		{s.handleReload, http.MethodGet, "/reload", "POST"},
+		{s.handleAllowlist, http.MethodPatch, "/allowlist", "GET, POST, DELETE"},
+	} {
+		rec := httptest.NewRecorder()
+		tc.handler(rec, httptest.NewRequest(tc.method, tc.path, nil))
+		if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != tc.allow {
+			t.Errorf("%s %s: 状态 %d, Allow %q", tc.method, tc.path, rec.Code, rec.Header().Get("Allow"))
+		}
+	}
+}
+
+func TestBlockTarget(t *testing.T) {
+	cfg := defaultConfig()
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{"203.0.113.7", "203.0.113.7"},
+		{" 203.0.113.7 ", "203.0.113.7"},
+		{"::ffff:203.0.113.7", "203.0.113.7"},
+		{"203.0.113.7/32", "203.0.113.7"},
+		{"198.51.100.77/24", "198.51.100.0/24"},
+		{"2001:db8:1:2::9", "2001:db8:1:2::/64"}, // 与检测时的来源键相同
+		{"2001:db8:1:2::9/128", "2001:db8:1:2::/64"},
+		{"2001:db8:1::/48", "2001:db8:1::/48"},
+	} {
+		got, err := blockTarget(cfg, tc.in)
+		if err != nil || got != tc.want {
+			t.Errorf("blockTarget(%q) = %q, %v, 期望 %q", tc.in, got, err, tc.want)
+		}
+	}
+	for _, bad := range []string{"", "blog.example.com", "203.0.113.300", "203.0.113.0/33"} {
+		if got, err := blockTarget(cfg, bad); err == nil {
+			t.Errorf("blockTarget(%q) = %q, 期望返回错误", bad, got)
+		}
+	}
+
+	cfg.IPv6SourcePrefix = 128
+	if got, _ := blockTarget(cfg, "2001:db8:1:2::9"); got != "2001:db8:1:2::9" {
+		t.Errorf("ipv6_source_prefix 为128时应该按单个地址阻塞: %q", got)
+	}
+}
+
+func TestAdminBlockAndUnblock(t *testing.T) {
+	s, cfg := setupAdminTest(t)
+
+	code, resp := adminDo(t, s.handleBlocks, http.MethodPost, "/blocks", `{"target":"2001:db8:1:2::9","duration":"10m"}`)
+	if code != http.StatusOK {
+		t.Fatalf("阻塞失败: %d %v", code, resp)
+	}
+	entry := blockedEntry("2001:db8:1:2::/64")
+	if entry == nil || entry.Reason != attackManual {
+		t.Fatalf("应该按/64阻塞: %+v", entry)
+	}
+	if d := entry.Expires.Sub(entry.BlockedAt); d != 10*time.Minute {
+		t.Errorf("阻塞时间 %s, 期望10m", d)
+	}
+
+	code, _ = adminDo(t, s.handleBlocks, http.MethodPost, "/blocks", `{"target":"203.0.113.7"}`)
+	if e := blockedEntry("203.0.113.7"); code != http.StatusOK || e == nil || e.Expires.Sub(e.BlockedAt) != cfg.BlockDuration {
+		t.Fatalf("不指定时间时应该使用 block_duration: %d %+v", code, e)
+	}
+	code, _ = adminDo(t, s.handleBlocks, http.MethodPost, "/blocks", `{"target":"203.0.113.7","permanent":true}`)
+	if e := blockedEntry("203.0.113.7"); code != http.StatusOK || e == nil || !e.Permanent() {
+		t.Fatalf("应该替换为永久阻塞: %d %+v", code, e)
+	}
+
+	for _, body := range []string{
+		`{"target":"203.0.113.8","duration":"soon"}`,
+		`{"target":"203.0.113.8","duration":"-1h"}`,
+		`{"target":"203.0.113.8","ttl":"1h"}`, // 未知字段
+		`{"target":"not-an-ip"}`,
+		`not json`,
+	} {
+		if code, resp := adminDo(t, s.handleBlocks, http.MethodPost, "/blocks", body); code != http.StatusBadRequest || resp["status"] != "error" {
+			t.Errorf("%s: 状态 %d, 期望400", body, code)
+		}
+	}
+
+	code, resp = adminDo(t, s.handleBlocks, http.MethodGet, "/blocks", "")
+	if blocks, _ := resp["blocks"].([]any); code != http.StatusOK || len(blocks) != 2 {
+		t.Fatalf("阻塞列表: %d %v", code, resp)
+	}
+
+	// 解除阻塞时同样规范化目标, 并清除违规记录
+	mu.Lock()
+	offenses["2001:db8:1:2::/64"] = &offenseRecord{count: 3, lastOffense: time.Now()}
+	mu.Unlock()
+	code, _ = adminDo(t, s.handleBlocks, http.MethodDelete, "/blocks?target=2001:db8:1:2::1", "")
+	if code != http.StatusOK || blockedEntry("2001:db8:1:2::/64") != nil {
+		t.Fatalf("解除阻塞失败: %d", code)
+	}
+	mu.RLock()
+	_, hasOffense := offenses["2001:db8:1:2::/64"]
+	mu.RUnlock()
+	if hasOffense {
+		t.Error("手动解除阻塞后应该清除违规记录")
+	}
+
+	if code, _ := adminDo(t, s.handleBlocks, http.MethodDelete, "/blocks?target=2001:db8:1:2::1", ""); code != http.StatusNotFound {
+		t.Errorf("解除没有被阻塞的目标: 状态 %d, 期望404", code)
+	}
+	if code, _ := adminDo(t, s.handleBlocks, http.MethodDelete, "/blocks", ""); code != http.StatusBadRequest {
+		t.Errorf("缺少目标: 状态 %d, 期望400", code)
+	}
+}
+
+func TestAdminAllowlist(t *testing.T) {
+	s, _ := setupAdminTest(t)
+	trusted.SetConfigured([]netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")})
+
+	// 与可信来源重叠的目标不能阻塞
+	for _, target := range []string{"192.0.2.5", "192.0.0.0/16"} {
+		code, _ := adminDo(t, s.handleBlocks, http.MethodPost, "/blocks", `{"target":"`+target+`"}`)
+		if code != http.StatusConflict || blockedEntry(target) != nil {
+			t.Errorf("%s: 状态 %d, 期望409", target, code)
+		}
+	}
+
+	// 运行中添加的可信来源立即解除已有的阻塞
+	adminDo(t, s.handleBlocks, http.MethodPost, "/blocks", `{"target":"198.51.100.7"}`)
+	if code, _ := adminDo(t, s.handleAllowlist, http.MethodPost, "/allowlist", `{"target":"198.51.100.0/24"}`); code != http.StatusOK {
+		t.Fatalf("添加可信来源: 状态 %d", code)
+	}
+	if blockedEntry("198.51.100.7") != nil {
+		t.Error("新的可信来源应该被解除阻塞")
+	}
+
+	code, resp := adminDo(t, s.handleAllowlist, http.MethodGet, "/allowlist", "")
+	configured, _ := resp["configured"].([]any)
+	runtime, _ := resp["runtime"].([]any)
+	if code != http.StatusOK || len(configured) != 1 || len(runtime) != 1 || runtime[0] != "198.51.100.0/24" {
+		t.Fatalf("可信来源列表: %d %v", code, resp)
+	}
+
+	// 配置文件中的网段不能在运行中删除
+	if code, _ := adminDo(t, s.handleAllowlist, http.MethodDelete, "/allowlist?target=192.0.2.0/24", ""); code != http.StatusNotFound {
+		t.Errorf("删除配置中的网段: 状态 %d, 期望404", code)
+	}
+	if code, _ := adminDo(t, s.handleAllowlist, http.MethodDelete, "/allowlist?target=198.51.100.0/24", ""); code != http.StatusOK {
+		t.Errorf("删除运行中添加的网段: 状态 %d", code)
+	}
+	if code, _ := adminDo(t, s.handleAllowlist, http.MethodPost, "/allowlist", `{"target":"nope"}`); code != http.StatusBadRequest {
+		t.Errorf("无效的网段: 状态 %d, 期望400", code)
+	}
+}
+
+func TestAdminConfigRedactsSecrets(t *testing.T) {
+	s, cfg := setupAdminTest(t)
+	cfg.Alerts.Sinks = []alertSinkConfig{
+		{Type: "slack", URL: "https://hooks.slack.com/services/T000/B000/XXXXSECRET"},
+		{Type: "webhook", URL: "https://alerts.example.com/hook?token=XXXXSECRET"},
+		{Type: "smtp", SMTP: smtpConfig{Server: "smtp.example.com:587", Username: "blog", Password: "XXXXSECRET", From: "a@example.com", To: []string{"b@example.com"}}},
+	}
+
+	rec := httptest.NewRecorder()
+	s.handleConfig(rec, httptest.NewRequest(http.MethodGet, "/config", nil))
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("状态 %d: %s", rec.Code, body)
+	}
+	if strings.Contains(body, "XXXXSECRET") {
+		t.Fatalf("配置中包含密码或令牌:\n%s", body)
+	}
+	for _, want := range []string{"https://hooks.slack.com/******", "https://alerts.example.com/******", "password: '******'"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("缺少 %q:\n%s", want, body)
+		}
+	}
+	// 只修改返回的副本
+	if cfg.Alerts.Sinks[2].SMTP.Password != "XXXXSECRET" || !strings.HasSuffix(cfg.Alerts.Sinks[0].URL, "XXXXSECRET") {
+		t.Error("生效的配置被修改")
+	}
+}
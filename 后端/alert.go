@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// 攻击告警
+//
+// 检测结果(单个来源或网段被阻塞、聚合泛洪)按攻击事件合并: 第一次检测时发出"攻击开始",
+// 之后的检测只计入当前事件, 超过 episode_gap 没有新的检测且聚合泛洪已结束时
+// 发出包含统计的"攻击结束"。每个目的地单独限速, 间隔内的告警只保留最新的一条。
+// 发送在后台goroutine中进行, 不阻塞数据包处理。
+
+const (
+	alertEventQueue  = 1024
+	alertSinkQueue   = 16
+	alertMaxTargets  = 20 // 告警中最多列出的阻塞目标
+	alertSendTimeout = 30 * time.Second
+)
+
+// 发送给各目的地的告警内容, 也是通用webhook的JSON格式
+type attackAlert struct {
+	Kind       string          `json:"kind"` // attack_started, attack_ended
+	Host       string          `json:"host"`
+	Episode    int             `json:"episode"` // 本次运行中的攻击事件序号
+	StartedAt  time.Time       `json:"started_at"`
+	EndedAt    *time.Time      `json:"ended_at,omitempty"`
+	Blocks     int             `json:"blocks"`  // 本次攻击中阻塞的目标数
+	Targets    []string        `json:"targets"` // 最多列出前 alertMaxTargets 个
+	Reasons    map[string]int  `json:"reasons"` // 各攻击类型的阻塞数
+	Aggregate  *aggregateAlert `json:"aggregate,omitempty"`
+	Suppressed int             `json:"suppressed,omitempty"` // 因限速被合并掉的告警数
+}
+
+// 处理线程交给告警goroutine的检测结果
+type alertEvent struct {
+	block          *blockEntry
+	aggregate      *aggregateAlert
+	aggregateEnded bool
+}
+
+// 一次攻击事件
+type attackEpisode struct {
+	alert           attackAlert
+	lastEvent       time.Time
+	aggregateActive bool
+}
+
+type alerter struct {
+	gap      time.Duration
+	host     string
+	events   chan alertEvent
+	sinks    []*alertSink
+	episodes int
+	current  *attackEpisode // 只由run访问
+}
+
+// 全局告警器, 没有配置目的地时为nil
+var alerts *alerter
+
+// 发送告警的方式
+type alertSender interface {
+	Send(a *attackAlert) error
+}
+
+// 一个告警目的地及其限速状态
+type alertSink struct {
+	name        string
+	sender      alertSender
+	minInterval time.Duration
+	queue       chan attackAlert
+}
+
+// 按配置创建告警器并启动后台goroutine, 没有配置目的地时返回nil
+func newAlerter(c alertConfig) *alerter {
+	if len(c.Sinks) == 0 {
+		return nil
+	}
+	host, _ := os.Hostname()
+	a := &alerter{
+		gap:    c.EpisodeGap,
+		host:   host,
+		events: make(chan alertEvent, alertEventQueue),
+	}
+	for _, sc := range c.Sinks {
+		s := &alertSink{
+			name:        cmp.Or(sc.Name, sc.Type),
+			sender:      newAlertSender(sc),
+			minInterval: sc.MinInterval,
+			queue:       make(chan attackAlert, alertSinkQueue),
+		}
+		a.sinks = append(a.sinks, s)
+		go s.run()
+	}
+	go a.run()
+	return a
+}
+
+func newAlertSender(sc alertSinkConfig) alertSender {
+	client := &http.Client{Timeout: alertSendTimeout}
+	switch sc.Type {
+	case "slack":
+		return &slackSender{url: sc.URL, client: client}
+	case "smtp":
+		return &smtpSender{cfg: sc.SMTP}
+	}
+	return &webhookSender{url: sc.URL, client: client}
+}
+
+// 记录一次阻塞, 调用时通常持有mu, 队列满时丢弃而不等待
+func (a *alerter) Block(entry *blockEntry) {
+	a.post(alertEvent{block: entry})
+}
+
+// 记录一次聚合泛洪告警
+func (a *alerter) AggregateFlood(alert aggregateAlert) {
+	a.post(alertEvent{aggregate: &alert})
+}
+
+// 聚合泛洪已结束, 攻击事件可以在 episode_gap 之后结束
+func (a *alerter) AggregateFloodEnded() {
+	a.post(alertEvent{aggregateEnded: true})
+}
+
+func (a *alerter) post(ev alertEvent) {
+	if a == nil {
+		return
+	}
+	select {
+	case a.events <- ev:
+	default:
+		// 攻击期间检测结果很多, 丢掉一部分只影响统计, 攻击事件仍然有效
+	}
+}
+
+func (a *alerter) run() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case ev := <-a.events:
+			a.handle(ev, time.Now())
+		case now := <-ticker.C:
+			a.maybeEnd(now)
+		}
+	}
+}
+
+func (a *alerter) handle(ev alertEvent, now time.Time) {
+	ep := a.current
+	started := ep == nil
+	if started {
+		if ev.aggregateEnded {
+			return
+		}
+		a.episodes++
+		ep = &attackEpisode{alert: attackAlert{
+			Kind:      "attack_started",
+			Host:      a.host,
+			Episode:   a.episodes,
+			StartedAt: now,
+			Targets:   []string{},
+			Reasons:   make(map[string]int),
+		}}
+		a.current = ep
+	}
+
+	ep.lastEvent = now
+	switch {
+	case ev.block != nil:
+		ep.alert.Blocks++
+		ep.alert.Reasons[string(ev.block.Reason)]++
+		if len(ep.alert.Targets) < alertMaxTargets {
+			ep.alert.Targets = append(ep.alert.Targets, ev.block.Target)
+		}
+	case ev.aggregate != nil:
+		ep.aggregateActive = true
+		// 保留本次攻击中速率最高的一次
+		if ep.alert.Aggregate == nil || ev.aggregate.PPS > ep.alert.Aggregate.PPS {
+			ep.alert.Aggregate = ev.aggregate
+		}
+	case ev.aggregateEnded:
+		ep.aggregateActive = false
+	}
+
+	if started {
+		a.dispatch(ep.alert)
+	}
+}
+
+// 攻击事件在最后一次检测后 episode_gap 内没有新的检测时结束
+func (a *alerter) maybeEnd(now time.Time) {
+	ep := a.current
+	if ep == nil || ep.aggregateActive || now.Sub(ep.lastEvent) < a.gap {
+		return
+	}
+	a.current = nil
+	ep.alert.Kind = "attack_ended"
+	ep.alert.EndedAt = &now
+	a.dispatch(ep.alert)
+}
+
+// 把告警的副本交给每个目的地, 目的地的队列满时丢弃
+func (a *alerter) dispatch(alert attackAlert) {
+	alert.Targets = slices.Clone(alert.Targets)
+	alert.Reasons = maps.Clone(alert.Reasons)
+	logEvent(slog.LevelInfo, "alert_"+alert.Kind, "发送攻击告警",
+		slog.Int("episode", alert.Episode), slog.Int("blocks", alert.Blocks))
+	for _, s := range a.sinks {
+		select {
+		case s.queue <- alert:
+		default:
+			logEvent(slog.LevelWarn, "alert_error", "告警队列已满, 丢弃告警", slog.String("sink", s.name))
+		}
+	}
+}
+
+// 按 min_interval 限速发送, 间隔内到达的告警替换尚未发送的告警
+func (s *alertSink) run() {
+	var (
+		pending *attackAlert
+		last    time.Time
+		wait    <-chan time.Time
+	)
+	for {
+		select {
+		case alert := <-s.queue:
+			if pending != nil {
+				alert.Suppressed += pending.Suppressed + 1
+			}
+			pending = &alert
+		case <-wait:
+			wait = nil
+		}
+		if pending == nil || wait != nil {
+			continue
+		}
+		if d := s.minInterval - time.Since(last); d > 0 {
+			wait = time.After(d)
+			continue
+		}
+		if err := s.sender.Send(pending); err != nil {
+			logEvent(slog.LevelError, "alert_error", "发送告警失败",
+				slog.String("sink", s.name), slog.Int("episode", pending.Episode), slog.String("error", err.Error()))
+		}
+		last = time.Now()
+		pending = nil
+	}
+}
+
+// 告警标题
+func (a *attackAlert) Subject() string {
+	if a.Kind == "attack_ended" {
+		return fmt.Sprintf("[blogguard] %s 的攻击已结束 (#%d)", a.Host, a.Episode)
+	}
+	return fmt.Sprintf("[blogguard] %s 检测到攻击 (#%d)", a.Host, a.Episode)
+}
+
+// 告警正文, 用于Slack和邮件
+func (a *attackAlert) Text() string {
+	var b strings.Builder
+	b.WriteString(a.Subject() + "\n")
+	fmt.Fprintf(&b, "开始时间: %s\n", a.StartedAt.Format(time.RFC3339))
+	if a.EndedAt != nil {
+		fmt.Fprintf(&b, "结束时间: %s (持续 %s)\n", a.EndedAt.Format(time.RFC3339), a.EndedAt.Sub(a.StartedAt).Round(time.Second))
+	}
+	if a.Blocks > 0 {
+		reasons := slices.Sorted(maps.Keys(a.Reasons))
+		parts := make([]string, len(reasons))
+		for i, r := range reasons {
+			parts[i] = fmt.Sprintf("%s %d", r, a.Reasons[r])
+		}
+		fmt.Fprintf(&b, "已阻塞: %d 个 (%s)\n", a.Blocks, strings.Join(parts, ", "))
+		targets := strings.Join(a.Targets, ", ")
+		if a.Blocks > len(a.Targets) {
+			targets += ", ..."
+		}
+		fmt.Fprintf(&b, "阻塞目标: %s\n", targets)
+	}
+	if agg := a.Aggregate; agg != nil {
+		fmt.Fprintf(&b, "聚合泛洪: 峰值 %.0f 包/秒, 基线 %.0f 包/秒\n", agg.PPS, agg.Baseline)
+		for _, hh := range agg.Top {
+			fmt.Fprintf(&b, "  %s  %d\n", hh.Key, hh.Count)
+		}
+	}
+	if a.Suppressed > 0 {
+		fmt.Fprintf(&b, "(限速期间合并了 %d 条告警)\n", a.Suppressed)
+	}
+	return b.String()
+}
+
+// 通用JSON webhook: 直接POST告警内容
+type webhookSender struct {
+	url    string
+	client *http.Client
+}
+
+func (w *webhookSender) Send(a *attackAlert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return postJSON(w.client, w.url, body)
+}
+
+// Slack兼容的incoming webhook, 只使用text字段, Mattermost等也支持
+type slackSender struct {
+	url    string
+	client *http.Client
+}
+
+func (s *slackSender) Send(a *attackAlert) error {
+	body, err := json.Marshal(map[string]string{"text": a.Text()})
+	if err != nil {
+		return err
+	}
+	return postJSON(s.client, s.url, body)
+}
+
+func postJSON(client *http.Client, url string, body []byte) error {
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook返回 %s", resp.Status)
+	}
+	return nil
+}
+
+// 邮件告警, 服务器支持时使用STARTTLS, 配置了用户名时使用PLAIN认证
+type smtpSender struct {
+	cfg smtpConfig
+}
+
+func (s *smtpSender) Send(a *attackAlert) error {
+	host, _, _ := net.SplitHostPort(s.cfg.Server) // 已在配置校验中检查
+	conn, err := net.DialTimeout("tcp", s.cfg.Server, alertSendTimeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(alertSendTimeout))
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if s.cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.cfg.From); err != nil {
+		return err
+	}
+	for _, to := range s.cfg.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(s.message(a)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func (s *smtpSender) message(a *attackAlert) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", s.cfg.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(s.cfg.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.BEncoding.Encode("utf-8", a.Subject()))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(a.Text(), "\n", "\r\n"))
+	return b.Bytes()
+}
+
+// 管理接口显示配置时隐藏密码和webhook地址中的令牌
+func (c alertConfig) redacted() alertConfig {
+	c.Sinks = slices.Clone(c.Sinks)
+	for i := range c.Sinks {
+		sink := &c.Sinks[i]
+		if sink.SMTP.Password != "" {
+			sink.SMTP.Password = "******"
+		}
+		if u, err := url.Parse(sink.URL); err == nil && (u.Path != "" || u.RawQuery != "") {
+			sink.URL = u.Scheme + "://" + u.Host + "/******"
+		}
+	}
+	return c
+}
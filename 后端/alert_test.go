@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testAlert() *attackAlert {
+	started := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	ended := started.Add(90 * time.Second)
+	return &attackAlert{
+		Kind:      "attack_ended",
+		Host:      "blog",
+		Episode:   3,
+		StartedAt: started,
+		EndedAt:   &ended,
+		Blocks:    2,
+		Targets:   []string{"203.0.113.7", "198.51.100.0/24"},
+		Reasons:   map[string]int{"syn_flood": 1, "flood": 1},
+		Aggregate: &aggregateAlert{At: started, PPS: 52000, Baseline: 800,
+			Top: []heavyHitter{{Key: "dport tcp/443", Count: 51000}}},
+	}
+}
+
+// 记录收到的请求体
+func recordingServer(t *testing.T, status int) (*httptest.Server, chan []byte) {
+	t.Helper()
+	bodies := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type: %q", ct)
+		}
+		body, _ := io.ReadAll(r.Body)
+		bodies <- body
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, bodies
+}
+
+func TestWebhookSender(t *testing.T) {
+	srv, bodies := recordingServer(t, http.StatusOK)
+	sender := newAlertSender(alertSinkConfig{Type: "webhook", URL: srv.URL})
+	if err := sender.Send(testAlert()); err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]any
+	if err := json.Unmarshal(<-bodies, &got); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]any{
+		"kind":       "attack_ended",
+		"host":       "blog",
+		"episode":    3.0,
+		"started_at": "2026-03-01T12:00:00Z",
+		"ended_at":   "2026-03-01T12:01:30Z",
+		"blocks":     2.0,
+	} {
+		if got[key] != want {
+			t.Errorf("%s: 得到 %v, 期望 %v", key, got[key], want)
+		}
+	}
+	if targets, _ := got["targets"].([]any); len(targets) != 2 || targets[1] != "198.51.100.0/24" {
+		t.Errorf("targets: %v", got["targets"])
+	}
+	if agg, _ := got["aggregate"].(map[string]any); agg == nil || agg["pps"] != 52000.0 {
+		t.Errorf("aggregate: %v", got["aggregate"])
+	}
+	if _, ok := got["suppressed"]; ok {
+		t.Error("suppressed 为0时不应该出现")
+	}
+}
+
+func TestWebhookSenderErrorStatus(t *testing.T) {
+	srv, bodies := recordingServer(t, http.StatusBadGateway)
+	sender := newAlertSender(alertSinkConfig{Type: "webhook", URL: srv.URL})
+	err := sender.Send(testAlert())
+	<-bodies
+	if err == nil || !strings.Contains(err.Error(), "502") {
+		t.Fatalf("期望返回502错误, 得到 %v", err)
+	}
+}
+
+func TestSlackSender(t *testing.T) {
+	srv, bodies := recordingServer(t, http.StatusOK)
+	sender := newAlertSender(alertSinkConfig{Type: "slack", URL: srv.URL})
+	if err := sender.Send(testAlert()); err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]string
+	if err := json.Unmarshal(<-bodies, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("只应该有text字段: %v", got)
+	}
+	want := "[blogguard] blog 的攻击已结束 (#3)\n" +
+		"开始时间: 2026-03-01T12:00:00Z\n" +
+		"结束时间: 2026-03-01T12:01:30Z (持续 1m30s)\n" +
+		"已阻塞: 2 个 (flood 1, syn_flood 1)\n" +
+		"阻塞目标: 203.0.113.7, 198.51.100.0/24\n" +
+		"聚合泛洪: 峰值 52000 包/秒, 基线 800 包/秒\n" +
+		"  dport tcp/443  51000\n"
+	if got["text"] != want {
+		t.Fatalf("text 不符\n得到:\n%s\n期望:\n%s", got["text"], want)
+	}
+}
+
+// 最小的SMTP服务器, 只接收一封邮件
+type smtpStub struct {
+	addr  string
+	from  string
+	rcpts []string
+	data  string
+	done  chan error
+}
+
+func startSMTPStub(t *testing.T) *smtpStub {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &smtpStub{addr: ln.Addr().String(), done: make(chan error, 1)}
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.done <- err
+			return
+		}
+		defer conn.Close()
+		s.done <- s.serve(textproto.NewConn(conn))
+	}()
+	return s
+}
+
+func (s *smtpStub) serve(c *textproto.Conn) error {
+	c.PrintfLine("220 stub ESMTP")
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return err
+		}
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch cmd {
+		case "EHLO", "HELO":
+			c.PrintfLine("250 stub") // 不支持STARTTLS和认证
+		case "MAIL":
+			s.from = line
+			c.PrintfLine("250 ok")
+		case "RCPT":
+			s.rcpts = append(s.rcpts, line)
+			c.PrintfLine("250 ok")
+		case "DATA":
+			c.PrintfLine("354 go ahead")
+			data, err := io.ReadAll(bufio.NewReader(c.DotReader()))
+			if err != nil {
+				return err
+			}
+			s.data = string(data)
+			c.PrintfLine("250 queued")
+		case "QUIT":
+			c.PrintfLine("221 bye")
+			return nil
+		default:
+			c.PrintfLine("502 unknown command")
+		}
+	}
+}
+
+func TestSMTPSender(t *testing.T) {
+	stub := startSMTPStub(t)
+	sender := newAlertSender(alertSinkConfig{Type: "smtp", SMTP: smtpConfig{
+		Server: stub.addr,
+		From:   "blogguard@example.com",
+		To:     []string{"ops@example.com", "admin@example.com"},
+	}})
+	if err := sender.Send(testAlert()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-stub.done; err != nil {
+		t.Fatal(err)
+	}
+
+	if stub.from != "MAIL FROM:<blogguard@example.com>" {
+		t.Errorf("MAIL: %q", stub.from)
+	}
+	if len(stub.rcpts) != 2 || stub.rcpts[1] != "RCPT TO:<admin@example.com>" {
+		t.Errorf("RCPT: %q", stub.rcpts)
+	}
+	header, body, _ := strings.Cut(stub.data, "\n\n")
+	for _, want := range []string{
+		"From: blogguard@example.com",
+		"To: ops@example.com, admin@example.com",
+		"Subject: =?utf-8?b?",
+		"Content-Type: text/plain; charset=utf-8",
+	} {
+		if !strings.Contains(header, want) {
+			t.Errorf("邮件头缺少 %q:\n%s", want, header)
+		}
+	}
+	if body != testAlert().Text() {
+		t.Errorf("正文不符:\n%s", body)
+	}
+}
+
+// 创建不启动后台goroutine的告警器, 告警留在目的地队列中
+func testAlerter() (*alerter, *alertSink) {
+	sink := &alertSink{name: "test", queue: make(chan attackAlert, alertSinkQueue)}
+	return &alerter{gap: time.Minute, host: "blog", sinks: []*alertSink{sink}}, sink
+}
+
+func nextAlert(t *testing.T, sink *alertSink) attackAlert {
+	t.Helper()
+	select {
+	case a := <-sink.queue:
+		return a
+	default:
+		t.Fatal("没有发出告警")
+		return attackAlert{}
+	}
+}
+
+func assertNoAlert(t *testing.T, sink *alertSink) {
+	t.Helper()
+	select {
+	case a := <-sink.queue:
+		t.Fatalf("不应该发出告警: %s", a.Kind)
+	default:
+	}
+}
+
+func TestAlerterEpisode(t *testing.T) {
+	a, sink := testAlerter()
+	t0 := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	a.handle(alertEvent{block: &blockEntry{Target: "203.0.113.7", Reason: attackSYNFlood}}, t0)
+	started := nextAlert(t, sink)
+	if started.Kind != "attack_started" || started.Episode != 1 || started.Blocks != 1 || started.Targets[0] != "203.0.113.7" {
+		t.Fatalf("攻击开始告警不符: %+v", started)
+	}
+
+	// 之后的检测只计入当前事件
+	a.handle(alertEvent{block: &blockEntry{Target: "203.0.113.0/24", Reason: attackFlood}}, t0.Add(10*time.Second))
+	a.handle(alertEvent{aggregate: &aggregateAlert{PPS: 40000}}, t0.Add(20*time.Second))
+	a.handle(alertEvent{aggregate: &aggregateAlert{PPS: 52000}}, t0.Add(30*time.Second))
+	a.handle(alertEvent{aggregate: &aggregateAlert{PPS: 45000}}, t0.Add(40*time.Second))
+	assertNoAlert(t, sink)
+
+	// 聚合泛洪还没有结束, 超过 episode_gap 也不结束事件
+	a.maybeEnd(t0.Add(5 * time.Minute))
+	assertNoAlert(t, sink)
+
+	a.handle(alertEvent{aggregateEnded: true}, t0.Add(6*time.Minute))
+	a.maybeEnd(t0.Add(6*time.Minute + 30*time.Second))
+	assertNoAlert(t, sink)
+
+	end := t0.Add(7 * time.Minute)
+	a.maybeEnd(end)
+	ended := nextAlert(t, sink)
+	if ended.Kind != "attack_ended" || ended.Episode != 1 || ended.EndedAt == nil || !ended.EndedAt.Equal(end) {
+		t.Fatalf("攻击结束告警不符: %+v", ended)
+	}
+	if ended.Blocks != 2 || len(ended.Targets) != 2 || ended.Reasons["syn_flood"] != 1 || ended.Reasons["flood"] != 1 {
+		t.Errorf("阻塞统计不符: %+v", ended)
+	}
+	if ended.Aggregate == nil || ended.Aggregate.PPS != 52000 {
+		t.Errorf("应该保留速率最高的聚合泛洪: %+v", ended.Aggregate)
+	}
+	// 已发出的告警是副本, 不受之后的事件影响
+	if len(started.Targets) != 1 {
+		t.Errorf("攻击开始告警被修改: %v", started.Targets)
+	}
+
+	// 下一次检测开始新的事件, 单独的聚合泛洪结束不会开始事件
+	a.handle(alertEvent{aggregateEnded: true}, end.Add(time.Minute))
+	assertNoAlert(t, sink)
+	a.handle(alertEvent{block: &blockEntry{Target: "192.0.2.1", Reason: attackUDPFlood}}, end.Add(2*time.Minute))
+	if next := nextAlert(t, sink); next.Episode != 2 || next.Blocks != 1 {
+		t.Fatalf("新事件不符: %+v", next)
+	}
+}
+
+// 把发送的告警交给测试
+type chanSender chan attackAlert
+
+func (c chanSender) Send(a *attackAlert) error {
+	c <- *a
+	return nil
+}
+
+func TestAlertSinkMinInterval(t *testing.T) {
+	sent := make(chanSender, 8)
+	sink := &alertSink{name: "test", sender: sent, minInterval: 300 * time.Millisecond, queue: make(chan attackAlert, alertSinkQueue)}
+	go sink.run()
+
+	receive := func() attackAlert {
+		t.Helper()
+		select {
+		case a := <-sent:
+			return a
+		case <-time.After(5 * time.Second):
+			t.Fatal("没有发送告警")
+			return attackAlert{}
+		}
+	}
+
+	sink.queue <- attackAlert{Kind: "attack_started", Episode: 1}
+	first := receive()
+	firstAt := time.Now()
+	if first.Episode != 1 || first.Suppressed != 0 {
+		t.Fatalf("第一条告警应该立即发送: %+v", first)
+	}
+
+	// 间隔内的告警合并为最新的一条
+	sink.queue <- attackAlert{Kind: "attack_ended", Episode: 1}
+	sink.queue <- attackAlert{Kind: "attack_started", Episode: 2}
+	sink.queue <- attackAlert{Kind: "attack_ended", Episode: 2}
+	second := receive()
+	if elapsed := time.Since(firstAt); elapsed < 250*time.Millisecond {
+		t.Errorf("第二条告警在 %s 后发送, 应该等待 min_interval", elapsed)
+	}
+	if second.Kind != "attack_ended" || second.Episode != 2 || second.Suppressed != 2 {
+		t.Fatalf("合并后的告警不符: %+v", second)
+	}
+	select {
+	case extra := <-sent:
+		t.Fatalf("被合并的告警不应该再发送: %+v", extra)
+	case <-time.After(400 * time.Millisecond):
+	}
+}
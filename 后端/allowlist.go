@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 永不阻塞的可信来源, 例如nginx上游、监控探针、docker网桥网关和管理员的IP
+//
+// 配置文件中的网段在重新加载配置时整体替换, 运行中手动添加的网段单独保存,
+// 不受重新加载影响。
+type allowlist struct {
+	mu         sync.RWMutex
+	configured []netip.Prefix
+	runtime    []netip.Prefix
+}
+
+// 全局可信来源列表
+var trusted = &allowlist{}
+
+// 解析IP或CIDR, 单个IP视为/32或/128
+func parsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// 解析配置中的可信来源列表
+func parseAllowlist(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		prefix, err := parsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("无效的可信来源 %q: %w", entry, err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// 判断地址是否属于可信来源
+func (a *allowlist) Contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for _, prefix := range a.configured {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	for _, prefix := range a.runtime {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// 判断阻塞目标(IP或网段)是否与可信来源重叠
+func (a *allowlist) Overlaps(target string) bool {
+	prefix, err := parsePrefix(target)
+	if err != nil {
+		return false
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for _, p := range a.configured {
+		if p.Overlaps(prefix) {
+			return true
+		}
+	}
+	for _, p := range a.runtime {
+		if p.Overlaps(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// 替换来自配置文件的网段
+func (a *allowlist) SetConfigured(prefixes []netip.Prefix) {
+	a.mu.Lock()
+	a.configured = prefixes
+	a.mu.Unlock()
+}
+
+// 运行中添加可信网段
+func (a *allowlist) Add(prefix netip.Prefix) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, p := range a.runtime {
+		if p == prefix {
+			return
+		}
+	}
+	a.runtime = append(a.runtime, prefix)
+}
+
+// 删除运行中添加的可信网段, 配置文件中的网段只能通过修改配置删除
+func (a *allowlist) Remove(prefix netip.Prefix) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i, p := range a.runtime {
+		if p == prefix {
+			a.runtime = append(a.runtime[:i], a.runtime[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// 返回所有可信网段
+func (a *allowlist) List() (configured, runtime []netip.Prefix) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return append([]netip.Prefix(nil), a.configured...), append([]netip.Prefix(nil), a.runtime...)
+}
+
+// 把已被阻塞但属于可信来源的目标解除阻塞, 调用时需持有mu
+func releaseTrustedLocked(now time.Time) {
+	for target := range blockedIPs {
+		if trusted.Overlaps(target) {
+			unblockLocked(target, "allowlisted", now)
+		}
+	}
+}
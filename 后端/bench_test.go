@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// 性能测试: 用合成的数据包测量处理流水线每秒能处理多少个数据包
+//
+// 分别测试快速解析和完整解析两条路径, 不需要网卡和root权限。
+// 合成流量来自大量不同的来源, 每个来源和网段的速率都在阈值以下,
+// 测的是正常流量下的处理开销; 防火墙和数据包打印都被关闭。
+//
+//	go test -run '^$' -bench . -benchtime 1000000x
+const benchSources = 1 << 16
+
+var benchPackets [][]byte
+
+// 合成数据包只生成一次, 不计入测试时间
+func setupBenchmark(b *testing.B) [][]byte {
+	b.Helper()
+	if benchPackets == nil {
+		packets, err := synthesizePackets(benchSources)
+		if err != nil {
+			b.Fatalf("无法生成测试数据包: %v", err)
+		}
+		benchPackets = packets
+	}
+	fw = newFirewall(noopEnforcer{})
+	level := logLevel.Level()
+	logLevel.Set(slog.LevelWarn) // 只测处理开销, 不输出逐包和调试日志
+	b.Cleanup(func() { logLevel.Set(level) })
+	return benchPackets
+}
+
+// 测试的处理线程数: 1, 2, 4 和CPU核数
+func benchWorkerCounts() []int {
+	counts := []int{1}
+	for _, c := range []int{2, 4, runtime.NumCPU()} {
+		if c > counts[len(counts)-1] {
+			counts = append(counts, c)
+		}
+	}
+	return counts
+}
+
+// 在结果中报告每秒处理的数据包数, 流水线包括等待处理线程处理完积压的数据包
+func reportPacketRate(b *testing.B) {
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "pkts/s")
+}
+
+// 只解析数据包, 不经过检测流程
+func BenchmarkDecode(b *testing.B) {
+	packets := setupBenchmark(b)
+	fast, err := newFastDecoder(layers.LinkTypeEthernet)
+	if err != nil {
+		b.Fatal(err) // 以太网总是支持的
+	}
+
+	b.Run("fast", func(b *testing.B) {
+		b.ReportAllocs()
+		var info packetInfo
+		for i := 0; i < b.N; i++ {
+			fast.Decode(packets[i%len(packets)], &info)
+		}
+		reportPacketRate(b)
+	})
+	b.Run("full", func(b *testing.B) {
+		b.ReportAllocs()
+		var info packetInfo
+		for i := 0; i < b.N; i++ {
+			packet := gopacket.NewPacket(packets[i%len(packets)], layers.LinkTypeEthernet, gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+			decodePacket(packet, &info)
+		}
+		reportPacketRate(b)
+	})
+}
+
+// 用新的流水线处理数据包, 包括解析、分发和检测
+//
+// 数据包的时间戳按每微秒一个递增, 相当于每秒一百万个数据包的流量。
+func BenchmarkPipeline(b *testing.B) {
+	packets := setupBenchmark(b)
+	cfg := defaultConfig()
+	fast, err := newFastDecoder(layers.LinkTypeEthernet)
+	if err != nil {
+		b.Fatal(err) // 以太网总是支持的
+	}
+
+	for _, decoder := range []string{"fast", "full"} {
+		for _, workers := range benchWorkerCounts() {
+			b.Run(fmt.Sprintf("%s/workers=%d", decoder, workers), func(b *testing.B) {
+				activeConfig.Store(cfg)
+				engine = newPipeline(workers, cfg.Pipeline.QueueSize)
+				engine.applyTrackingLimits(cfg)
+				aggregates = newAggregateMonitor(cfg.HeavyHitters, workers)
+				b.ReportAllocs()
+				b.ResetTimer()
+
+				base := time.Now()
+				var info packetInfo
+				for i := 0; i < b.N; i++ {
+					data, now := packets[i%len(packets)], base.Add(time.Duration(i)*time.Microsecond)
+					if decoder == "fast" {
+						if fast.Decode(data, &info) {
+							info.iface = "bench"
+							engine.DispatchInfo(&info, nil, now)
+						}
+						continue
+					}
+					packet := gopacket.NewPacket(data, layers.LinkTypeEthernet, gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+					engine.Dispatch(packet, "bench", now)
+				}
+				engine.Close()
+				reportPacketRate(b)
+			})
+		}
+	}
+}
+
+// 生成 count 个来自不同来源的以太网帧: 大部分是发往443端口的TCP, 其余是UDP和IPv6
+func synthesizePackets(count int) ([][]byte, error) {
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	payload := gopacket.Payload(make([]byte, 64))
+	packets := make([][]byte, 0, count)
+
+	for i := 0; i < count; i++ {
+		eth := &layers.Ethernet{
+			SrcMAC: net.HardwareAddr{0x02, 0, 0, 0, byte(i >> 8), byte(i)},
+			DstMAC: net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+		}
+		tcp := &layers.TCP{
+			SrcPort: layers.TCPPort(1024 + i%60000),
+			DstPort: 443,
+			SYN:     i%4 == 0,
+			ACK:     i%4 != 0,
+			Seq:     uint32(i),
+			Window:  65535,
+		}
+		udp := &layers.UDP{SrcPort: layers.UDPPort(1024 + i%60000), DstPort: 53}
+
+		// 每个来源都在不同的/24或/48网段内
+		var toSerialize []gopacket.SerializableLayer
+		switch {
+		case i%10 == 9:
+			eth.EthernetType = layers.EthernetTypeIPv6
+			ip := &layers.IPv6{
+				Version:    6,
+				HopLimit:   64,
+				NextHeader: layers.IPProtocolTCP,
+				SrcIP:      net.IP{0x20, 0x01, 0x0d, 0xb8, byte(i >> 8), byte(i), 0, 0, 0, 0, 0, 0, 0, 0, 0, 1},
+				DstIP:      net.ParseIP("2001:db8:ffff::1"),
+			}
+			tcp.SetNetworkLayerForChecksum(ip)
+			toSerialize = []gopacket.SerializableLayer{eth, ip, tcp, payload}
+		case i%10 >= 7:
+			eth.EthernetType = layers.EthernetTypeIPv4
+			ip := &layers.IPv4{
+				Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP,
+				SrcIP: net.IP{10, byte(i >> 8), byte(i), 1},
+				DstIP: net.IP{192, 0, 2, 1},
+			}
+			udp.SetNetworkLayerForChecksum(ip)
+			toSerialize = []gopacket.SerializableLayer{eth, ip, udp, payload}
+		default:
+			eth.EthernetType = layers.EthernetTypeIPv4
+			ip := &layers.IPv4{
+				Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP,
+				SrcIP: net.IP{10, byte(i >> 8), byte(i), 1},
+				DstIP: net.IP{192, 0, 2, 1},
+			}
+			tcp.SetNetworkLayerForChecksum(ip)
+			toSerialize = []gopacket.SerializableLayer{eth, ip, tcp, payload}
+		}
+
+		buf := gopacket.NewSerializeBuffer()
+		if err := gopacket.SerializeLayers(buf, opts, toSerialize...); err != nil {
+			return nil, err
+		}
+		packets = append(packets, buf.Bytes())
+	}
+	return packets, nil
+}
@@ -0,0 +1,415 @@
+// blogguard 通过管理接口操作运行中的监控程序
+//
+//	blogguard [-socket 路径 | -addr 地址] [-json] <命令> [参数]
+//
+// 命令:
+//
+//	status                       运行状态
+//	blocks list                  列出当前的阻塞
+//	block <IP或CIDR> [--for 1h]  手动阻塞, 不指定时间时使用配置中的 block_duration, --permanent 永久阻塞
+//	unblock <IP或CIDR>           手动解除阻塞
+//	top [-n 20]                  速率最高的来源和聚合重流量键
+//	reload                       重新加载配置文件, 与发送SIGHUP相同
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// 与监控程序中管理接口的响应对应, 只包含需要显示的字段
+type response struct {
+	Status          string   `json:"status"`
+	Message         string   `json:"message"`
+	RestartRequired []string `json:"restart_required"`
+}
+
+type status struct {
+	StartedAt       time.Time `json:"started_at"`
+	UptimeSeconds   float64   `json:"uptime_seconds"`
+	ConfigPath      string    `json:"config_path"`
+	Workers         int       `json:"workers"`
+	TrackedSources  int       `json:"tracked_sources"`
+	TrackedPrefixes int       `json:"tracked_prefixes"`
+	ActiveBlocks    int       `json:"active_blocks"`
+	Interfaces      []struct {
+		Name      string `json:"name"`
+		Received  uint64 `json:"received"`
+		Dropped   uint64 `json:"dropped"`
+		IfDropped uint64 `json:"if_dropped"`
+	} `json:"interfaces"`
+}
+
+type block struct {
+	Target           string    `json:"target"`
+	Reason           string    `json:"reason"`
+	Rate             float64   `json:"rate"`
+	Offense          int       `json:"offense"`
+	Interface        string    `json:"interface"`
+	BlockedAt        time.Time `json:"blocked_at"`
+	Expires          time.Time `json:"expires"`
+	Permanent        bool      `json:"permanent"`
+	RemainingSeconds float64   `json:"remaining_seconds"`
+}
+
+type blockList struct {
+	Blocks []block `json:"blocks"`
+}
+
+type top struct {
+	Sources []struct {
+		Source   string    `json:"source"`
+		Rate     float64   `json:"rate"`
+		LastSeen time.Time `json:"last_seen"`
+	} `json:"sources"`
+	Aggregates []struct {
+		Key   string `json:"key"`
+		Count uint64 `json:"count"`
+	} `json:"aggregates"`
+}
+
+// 与监控程序中 admin.socket 的默认值相同
+const defaultSocket = "/run/blogguard/blogguard.sock"
+
+// 所有命令共用的选项, 可以写在命令前面或后面
+type options struct {
+	socket string
+	addr   string
+	json   bool
+}
+
+func (o *options) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.socket, "socket", o.socket, "监控程序管理接口的unix套接字")
+	fs.StringVar(&o.addr, "addr", o.addr, "监控程序管理接口的TCP地址, 指定时不使用unix套接字")
+	fs.BoolVar(&o.json, "json", o.json, "输出管理接口返回的JSON")
+}
+
+func main() {
+	opts := &options{socket: defaultSocket}
+	if s := os.Getenv("BLOGGUARD_SOCKET"); s != "" {
+		opts.socket = s
+	}
+
+	fs := flag.NewFlagSet("blogguard", flag.ContinueOnError)
+	opts.register(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: blogguard [-socket 路径 | -addr 地址] [-json] <status|blocks list|block|unblock|top|reload> [参数]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		os.Exit(2)
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(opts, fs.Arg(0), fs.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "blogguard: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(opts *options, command string, args []string) error {
+	switch command {
+	case "status":
+		if _, err := parseArgs(command, args, opts, 0, nil); err != nil {
+			return err
+		}
+		return cmdStatus(opts)
+
+	case "blocks":
+		rest, err := parseArgs(command, args, opts, 1, nil)
+		if err != nil {
+			return err
+		}
+		if rest[0] != "list" {
+			return fmt.Errorf("未知的命令 blocks %s, 支持: blocks list", rest[0])
+		}
+		return cmdBlocks(opts)
+
+	case "block":
+		var duration time.Duration
+		var permanent bool
+		rest, err := parseArgs(command, args, opts, 1, func(fs *flag.FlagSet) {
+			fs.DurationVar(&duration, "for", 0, "阻塞时间, 例如 30m, 1h; 不指定时使用配置中的 block_duration")
+			fs.BoolVar(&permanent, "permanent", false, "永久阻塞")
+		})
+		if err != nil {
+			return err
+		}
+		if duration < 0 {
+			return fmt.Errorf("阻塞时间不能为负数: %s", duration)
+		}
+		if duration > 0 && permanent {
+			return errors.New("--for 和 --permanent 不能同时使用")
+		}
+		return cmdBlock(opts, rest[0], duration, permanent)
+
+	case "unblock":
+		rest, err := parseArgs(command, args, opts, 1, nil)
+		if err != nil {
+			return err
+		}
+		return cmdUnblock(opts, rest[0])
+
+	case "top":
+		n := 20
+		if _, err := parseArgs(command, args, opts, 0, func(fs *flag.FlagSet) {
+			fs.IntVar(&n, "n", n, "显示的来源数")
+		}); err != nil {
+			return err
+		}
+		return cmdTop(opts, n)
+
+	case "reload":
+		if _, err := parseArgs(command, args, opts, 0, nil); err != nil {
+			return err
+		}
+		return cmdReload(opts)
+	}
+	return fmt.Errorf("未知的命令 %s", command)
+}
+
+// 解析子命令的参数, 选项可以出现在位置参数前后, 例如 block 1.2.3.4 --for 1h
+func parseArgs(command string, args []string, opts *options, positional int, extra func(*flag.FlagSet)) ([]string, error) {
+	fs := flag.NewFlagSet("blogguard "+command, flag.ContinueOnError)
+	opts.register(fs)
+	if extra != nil {
+		extra(fs)
+	}
+
+	var rest []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		rest = append(rest, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(rest) != positional {
+		return nil, fmt.Errorf("%s 需要 %d 个参数, 收到 %d 个", command, positional, len(rest))
+	}
+	return rest, nil
+}
+
+// 管理接口的HTTP客户端
+func newClient(opts *options) (*http.Client, string) {
+	if opts.addr != "" {
+		return &http.Client{Timeout: 10 * time.Second}, "http://" + opts.addr
+	}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", opts.socket)
+		},
+	}
+	return &http.Client{Transport: transport, Timeout: 10 * time.Second}, "http://blogguard"
+}
+
+// 调用管理接口, 返回响应体; 接口返回错误时把其中的说明作为错误返回
+func call(opts *options, method, path string, body interface{}) ([]byte, error) {
+	client, base := newClient(opts)
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, base+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("无法连接监控程序: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var r response
+		if json.Unmarshal(data, &r) == nil && r.Message != "" {
+			return nil, errors.New(r.Message)
+		}
+		return nil, fmt.Errorf("管理接口返回 %s", resp.Status)
+	}
+	return data, nil
+}
+
+// 调用管理接口并解析响应, 使用 -json 时直接输出响应, 返回的 printed 为 true
+func fetch(opts *options, method, path string, body, v interface{}) (printed bool, err error) {
+	data, err := call(opts, method, path, body)
+	if err != nil {
+		return false, err
+	}
+	if opts.json {
+		var out bytes.Buffer
+		if err := json.Indent(&out, data, "", "  "); err != nil {
+			return false, err
+		}
+		_, err := out.WriteTo(os.Stdout)
+		return true, err
+	}
+	return false, json.Unmarshal(data, v)
+}
+
+func newTable() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+}
+
+func cmdStatus(opts *options) error {
+	var s status
+	if printed, err := fetch(opts, http.MethodGet, "/status", nil, &s); printed || err != nil {
+		return err
+	}
+
+	w := newTable()
+	uptime := time.Duration(s.UptimeSeconds * float64(time.Second)).Round(time.Second)
+	fmt.Fprintf(w, "运行时间:\t%s (自 %s)\n", uptime, s.StartedAt.Local().Format("2006-01-02 15:04:05"))
+	if s.ConfigPath != "" {
+		fmt.Fprintf(w, "配置文件:\t%s\n", s.ConfigPath)
+	}
+	fmt.Fprintf(w, "处理线程:\t%d\n", s.Workers)
+	fmt.Fprintf(w, "跟踪来源:\t%d 个来源, %d 个网段\n", s.TrackedSources, s.TrackedPrefixes)
+	fmt.Fprintf(w, "当前阻塞:\t%d\n", s.ActiveBlocks)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Println()
+	w = newTable()
+	fmt.Fprintln(w, "接口\t收到\t丢弃\t网卡丢弃")
+	for _, iface := range s.Interfaces {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", iface.Name, iface.Received, iface.Dropped, iface.IfDropped)
+	}
+	return w.Flush()
+}
+
+func cmdBlocks(opts *options) error {
+	var list blockList
+	if printed, err := fetch(opts, http.MethodGet, "/blocks", nil, &list); printed || err != nil {
+		return err
+	}
+	if len(list.Blocks) == 0 {
+		fmt.Println("当前没有阻塞")
+		return nil
+	}
+
+	w := newTable()
+	fmt.Fprintln(w, "目标\t原因\t速率\t违规次数\t接口\t阻塞时间\t剩余")
+	for _, b := range list.Blocks {
+		rate := "-"
+		if b.Rate > 0 {
+			rate = fmt.Sprintf("%.2f", b.Rate)
+		}
+		iface := b.Interface
+		if iface == "" {
+			iface = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n", b.Target, b.Reason, rate, b.Offense, iface,
+			b.BlockedAt.Local().Format("01-02 15:04:05"), remaining(b.Permanent, b.RemainingSeconds))
+	}
+	return w.Flush()
+}
+
+func remaining(permanent bool, seconds float64) string {
+	if permanent {
+		return "永久"
+	}
+	return time.Duration(seconds * float64(time.Second)).Round(time.Second).String()
+}
+
+func cmdBlock(opts *options, target string, duration time.Duration, permanent bool) error {
+	req := map[string]interface{}{"target": target, "permanent": permanent}
+	if duration > 0 {
+		req["duration"] = duration.String()
+	}
+	var list blockList
+	if printed, err := fetch(opts, http.MethodPost, "/blocks", req, &list); printed || err != nil {
+		return err
+	}
+	for _, b := range list.Blocks {
+		if b.Permanent {
+			fmt.Printf("已永久阻塞 %s\n", b.Target)
+		} else {
+			fmt.Printf("已阻塞 %s %s, 至 %s\n", b.Target, remaining(false, b.RemainingSeconds),
+				b.Expires.Local().Format("2006-01-02 15:04:05"))
+		}
+	}
+	return nil
+}
+
+func cmdUnblock(opts *options, target string) error {
+	var r response
+	if printed, err := fetch(opts, http.MethodDelete, "/blocks?target="+url.QueryEscape(target), nil, &r); printed || err != nil {
+		return err
+	}
+	fmt.Printf("已解除对 %s 的阻塞\n", target)
+	return nil
+}
+
+func cmdTop(opts *options, n int) error {
+	var t top
+	if printed, err := fetch(opts, http.MethodGet, fmt.Sprintf("/top?n=%d", n), nil, &t); printed || err != nil {
+		return err
+	}
+
+	w := newTable()
+	fmt.Fprintln(w, "来源\t速率(包/秒)\t最后出现")
+	for _, s := range t.Sources {
+		fmt.Fprintf(w, "%s\t%.2f\t%s\n", s.Source, s.Rate, s.LastSeen.Local().Format("15:04:05"))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if len(t.Aggregates) > 0 {
+		fmt.Println()
+		w = newTable()
+		fmt.Fprintln(w, "聚合键\t上个窗口的包数(估计)")
+		for _, hh := range t.Aggregates {
+			fmt.Fprintf(w, "%s\t%d\n", hh.Key, hh.Count)
+		}
+		return w.Flush()
+	}
+	return nil
+}
+
+func cmdReload(opts *options) error {
+	var r response
+	if printed, err := fetch(opts, http.MethodPost, "/reload", nil, &r); printed || err != nil {
+		return err
+	}
+	fmt.Println("配置已重新加载")
+	if len(r.RestartRequired) > 0 {
+		fmt.Printf("以下配置项需要重启才能生效: %s\n", strings.Join(r.RestartRequired, ", "))
+	}
+	return nil
+}
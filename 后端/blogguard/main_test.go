@@ -0,0 +1,185 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseArgs(t *testing.T) {
+	var duration time.Duration
+	var permanent bool
+	blockFlags := func(fs *flag.FlagSet) {
+		fs.SetOutput(io.Discard)
+		fs.DurationVar(&duration, "for", 0, "")
+		fs.BoolVar(&permanent, "permanent", false, "")
+	}
+
+	for _, tc := range []struct {
+		args      []string
+		rest      []string
+		duration  time.Duration
+		permanent bool
+		opts      options
+	}{
+		{[]string{"203.0.113.7"}, []string{"203.0.113.7"}, 0, false, options{}},
+		{[]string{"203.0.113.7", "--for", "1h"}, []string{"203.0.113.7"}, time.Hour, false, options{}},
+		{[]string{"--for", "30m", "203.0.113.7", "-json"}, []string{"203.0.113.7"}, 30 * time.Minute, false, options{json: true}},
+		{[]string{"-socket", "/tmp/a.sock", "2001:db8::/64", "--permanent", "-addr", "127.0.0.1:9090"},
+			[]string{"2001:db8::/64"}, 0, true, options{socket: "/tmp/a.sock", addr: "127.0.0.1:9090"}},
+		// -- 之后的参数都是位置参数
+		{[]string{"--for", "1m", "--", "-1"}, []string{"-1"}, time.Minute, false, options{}},
+	} {
+		duration, permanent = 0, false
+		var opts options
+		rest, err := parseArgs("block", tc.args, &opts, 1, blockFlags)
+		if err != nil {
+			t.Errorf("%q: %v", tc.args, err)
+			continue
+		}
+		if !slices.Equal(rest, tc.rest) || duration != tc.duration || permanent != tc.permanent || opts != tc.opts {
+			t.Errorf("%q: 得到 %q %s %v %+v", tc.args, rest, duration, permanent, opts)
+		}
+	}
+
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{nil, "block 需要 1 个参数, 收到 0 个"},
+		{[]string{"203.0.113.7", "198.51.100.1"}, "block 需要 1 个参数, 收到 2 个"},
+		{[]string{"203.0.113.7", "--for"}, "flag needs an argument"},
+		{[]string{"--for", "soon", "203.0.113.7"}, "invalid value"},
+		{[]string{"203.0.113.7", "--ttl", "1h"}, "flag provided but not defined"},
+	} {
+		var opts options
+		_, err := parseArgs("block", tc.args, &opts, 1, blockFlags)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%q: 错误 %v, 期望包含 %q", tc.args, err, tc.want)
+		}
+	}
+}
+
+// 记录收到的请求, 按 reply 返回响应
+type adminStub struct {
+	requests []string
+	reply    func(w http.ResponseWriter, r *http.Request)
+}
+
+func (s *adminStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.requests = append(s.requests, strings.TrimSpace(r.Method+" "+r.URL.RequestURI()+" "+string(body)))
+	if len(body) > 0 && r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "Content-Type", http.StatusUnsupportedMediaType)
+		return
+	}
+	if s.reply != nil {
+		s.reply(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, `{"status":"ok","blocks":[]}`)
+}
+
+func (s *adminStub) take() []string {
+	requests := s.requests
+	s.requests = nil
+	return requests
+}
+
+func TestRunRequests(t *testing.T) {
+	stub := &adminStub{}
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+	opts := &options{addr: strings.TrimPrefix(srv.URL, "http://")}
+
+	for _, tc := range []struct {
+		command string
+		args    []string
+		want    string
+	}{
+		{"status", nil, "GET /status"},
+		{"blocks", []string{"list"}, "GET /blocks"},
+		{"block", []string{"203.0.113.7", "--for", "1h"}, `POST /blocks {"duration":"1h0m0s","permanent":false,"target":"203.0.113.7"}`},
+		{"block", []string{"203.0.113.7"}, `POST /blocks {"permanent":false,"target":"203.0.113.7"}`},
+		{"block", []string{"--permanent", "2001:db8::/64"}, `POST /blocks {"permanent":true,"target":"2001:db8::/64"}`},
+		{"unblock", []string{"2001:db8::/64"}, "DELETE /blocks?target=2001%3Adb8%3A%3A%2F64"},
+		{"top", []string{"-n", "5"}, "GET /top?n=5"},
+		{"top", nil, "GET /top?n=20"},
+		{"reload", nil, "POST /reload"},
+	} {
+		if err := run(opts, tc.command, tc.args); err != nil {
+			t.Errorf("%s %q: %v", tc.command, tc.args, err)
+		}
+		if got := stub.take(); len(got) != 1 || got[0] != tc.want {
+			t.Errorf("%s %q: 请求 %q, 期望 %q", tc.command, tc.args, got, tc.want)
+		}
+	}
+
+	// 参数错误时不发送请求
+	for _, tc := range []struct {
+		command string
+		args    []string
+	}{
+		{"block", []string{"203.0.113.7", "--for", "1h", "--permanent"}},
+		{"block", []string{"203.0.113.7", "--for", "-1h"}},
+		{"blocks", []string{"show"}},
+		{"unblock", nil},
+		{"status", []string{"extra"}},
+		{"flush", nil},
+	} {
+		if err := run(opts, tc.command, tc.args); err == nil {
+			t.Errorf("%s %q: 期望返回错误", tc.command, tc.args)
+		}
+		if got := stub.take(); len(got) != 0 {
+			t.Errorf("%s %q: 不应该发送请求: %q", tc.command, tc.args, got)
+		}
+	}
+}
+
+func TestRunUnixSocketAndErrors(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "blogguard.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := &adminStub{reply: func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `{"status":"error","message":"203.0.113.9 没有被阻塞"}`)
+	}}
+	srv := httptest.NewUnstartedServer(stub)
+	srv.Listener.Close()
+	srv.Listener = l
+	srv.Start()
+	defer srv.Close()
+
+	// 管理接口的错误说明作为错误返回
+	opts := &options{socket: socket}
+	err = run(opts, "unblock", []string{"203.0.113.9"})
+	if err == nil || err.Error() != "203.0.113.9 没有被阻塞" {
+		t.Fatalf("错误 %v", err)
+	}
+	if got := stub.take(); len(got) != 1 || got[0] != "DELETE /blocks?target=203.0.113.9" {
+		t.Fatalf("请求 %q", got)
+	}
+
+	// 位置参数后面的 -socket 同样生效
+	opts = &options{socket: filepath.Join(t.TempDir(), "missing.sock")}
+	if err := run(opts, "status", []string{"-socket", socket}); err == nil {
+		t.Fatal("期望返回错误")
+	}
+	if got := stub.take(); len(got) != 1 || got[0] != "GET /status" {
+		t.Fatalf("请求 %q", got)
+	}
+	if err := run(&options{socket: filepath.Join(t.TempDir(), "missing.sock")}, "status", nil); err == nil || !strings.Contains(err.Error(), "无法连接监控程序") {
+		t.Fatalf("错误 %v", err)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+// 数据包来源, pcap句柄同时支持普通读取和零拷贝读取
+type captureSource interface {
+	gopacket.PacketDataSource
+	gopacket.ZeroCopyPacketDataSource
+}
+
+// afpacket环形缓冲区的帧大小, TPACKET_V3中数据包不受帧大小限制, 只影响块大小的取整
+const afpacketFrameSize = 4096
+
+// 一个打开的在线抓包句柄
+type liveCapture struct {
+	iface    string
+	source   captureSource
+	linkType layers.LinkType
+	close    func()
+	stats    func() (captureStats, error)
+	// 重新加载配置时按新配置替换内核中的过滤器
+	setFilter func(cfg *config) error
+}
+
+// 按配置的抓包后端打开接口, afpacket后端开启fanout时一个接口有多个句柄
+func openCaptures(cfg *config, iface string) ([]liveCapture, error) {
+	if cfg.CaptureBackend == "afpacket" {
+		return openAFPacket(cfg, iface)
+	}
+
+	handle, err := pcap.OpenLive(iface, int32(cfg.SnapLen), cfg.Promiscuous, pcap.BlockForever)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyCaptureFilter(handle, cfg, true); err != nil {
+		handle.Close()
+		return nil, err
+	}
+	stats := func() (captureStats, error) {
+		s, err := handle.Stats()
+		if err != nil {
+			return captureStats{}, err
+		}
+		return captureStats{
+			received:  uint64(s.PacketsReceived),
+			dropped:   uint64(s.PacketsDropped),
+			ifDropped: uint64(s.PacketsIfDropped),
+		}, nil
+	}
+	setFilter := func(cfg *config) error {
+		direction := pcap.DirectionInOut
+		if cfg.InboundOnly {
+			direction = pcap.DirectionIn
+		}
+		if err := handle.SetDirection(direction); err != nil {
+			return fmt.Errorf("无法设置抓包方向: %w", err)
+		}
+		// 空表达式匹配全部数据包, 同时清除之前的过滤器
+		if err := handle.SetBPFFilter(cfg.BPFFilter); err != nil {
+			return fmt.Errorf("无法设置BPF过滤器 %q: %w", cfg.BPFFilter, err)
+		}
+		return nil
+	}
+	return []liveCapture{{iface: iface, source: handle, linkType: handle.LinkType(), close: handle.Close, stats: stats, setFilter: setFilter}}, nil
+}
+
+// 在内核中过滤数据包, 只把需要检测的流量复制到用户态
+//
+// 离线文件中没有方向信息, 只应用过滤器。
+func applyCaptureFilter(handle *pcap.Handle, cfg *config, live bool) error {
+	if live && cfg.InboundOnly {
+		if err := handle.SetDirection(pcap.DirectionIn); err != nil {
+			logEvent(slog.LevelWarn, "capture_error", "无法只捕获入站流量, 将同时捕获出站流量", slog.String("error", err.Error()))
+		}
+	}
+	if cfg.BPFFilter == "" {
+		return nil
+	}
+	if err := handle.SetBPFFilter(cfg.BPFFilter); err != nil {
+		return fmt.Errorf("无法设置BPF过滤器 %q: %w", cfg.BPFFilter, err)
+	}
+	return nil
+}
+
+// 在所有打开的句柄上应用新的过滤条件, 不需要重新打开接口
+func setCaptureFilters(cfg *config) error {
+	capturesMu.Lock()
+	defer capturesMu.Unlock()
+	for _, c := range openHandles {
+		if err := c.setFilter(cfg); err != nil {
+			return fmt.Errorf("%s: %w", c.iface, err)
+		}
+	}
+	return nil
+}
+
+// 启动信息中显示的过滤条件
+func captureFilterDescription(cfg *config) string {
+	filter := cfg.BPFFilter
+	if filter == "" {
+		filter = "无(捕获全部流量)"
+	}
+	if cfg.InboundOnly {
+		filter += ", 只捕获入站"
+	}
+	return filter
+}
+
+// 读取数据包并分发给处理线程, 直到数据源结束或被关闭
+//
+// clock 返回数据包的处理时间: 在线监听时为当前时间, 离线分析时为数据包的时间戳。
+func capturePackets(source captureSource, linkType layers.LinkType, iface, decoder string, clock func(gopacket.CaptureInfo) time.Time) {
+	if decoder == "fast" {
+		fast, err := newFastDecoder(linkType)
+		if err == nil {
+			captureFast(source, linkType, iface, fast, clock)
+			return
+		}
+		logEvent(slog.LevelWarn, "decoder_fallback", "快速解析不支持该链路类型, 改用完整解析",
+			slog.String("interface", iface), slog.String("error", err.Error()))
+	}
+
+	packetSource := gopacket.NewPacketSource(source, linkType)
+	packetSource.DecodeOptions = gopacket.DecodeOptions{Lazy: true, NoCopy: true}
+	for packet := range packetSource.Packets() {
+		engine.Dispatch(packet, iface, clock(packet.Metadata().CaptureInfo))
+	}
+}
+
+// 快速解析路径: 零拷贝读取, 在抓包线程中解析, 只把解析结果交给处理线程
+func captureFast(source captureSource, linkType layers.LinkType, iface string, decoder *fastDecoder, clock func(gopacket.CaptureInfo) time.Time) {
+	var info packetInfo
+	failing := false // 连续的读取错误只记录第一次
+	for {
+		data, ci, err := source.ZeroCopyReadPacketData()
+		if err != nil {
+			if captureFinished(err) {
+				return
+			}
+			if err != pcap.NextErrorTimeoutExpired {
+				if !failing {
+					logEvent(slog.LevelError, "capture_error", "读取数据包失败",
+						slog.String("interface", iface), slog.String("error", err.Error()))
+					failing = true
+				}
+				time.Sleep(5 * time.Millisecond) // 与gopacket.PacketSource相同, 短暂等待后重试
+			}
+			continue
+		}
+		failing = false
+		now := clock(ci)
+		if !decoder.Decode(data, &info) {
+			continue
+		}
+		info.iface = iface
+
+		// 零拷贝读取的缓冲区在下次读取时会被覆盖, 逐包记录时需要复制一份
+		var packet gopacket.Packet
+		if packetTraceEnabled() {
+			packet = gopacket.NewPacket(append([]byte(nil), data...), linkType, gopacket.Default)
+		}
+		engine.DispatchInfo(&info, packet, now)
+	}
+}
+
+// 数据源已经结束或被关闭, 与gopacket.PacketSource的判断相同
+func captureFinished(err error) bool {
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, io.ErrNoProgress), errors.Is(err, io.ErrClosedPipe),
+		errors.Is(err, io.ErrShortBuffer), errors.Is(err, syscall.EBADF):
+		return true
+	}
+	return strings.Contains(err.Error(), "use of closed file")
+}
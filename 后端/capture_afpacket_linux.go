@@ -0,0 +1,201 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/gopacket/afpacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// AF_PACKET TPACKET_V3抓包后端
+//
+// 内核把数据包按块写入与用户态共享的环形缓冲区, 读取时不需要系统调用和复制。
+// fanout大于1时每个接口打开多个套接字组成一个fanout组, 由内核把数据包分给它们,
+// 每个套接字有自己的抓包goroutine和解析器。
+func openAFPacket(cfg *config, iface string) ([]liveCapture, error) {
+	dev, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkEthernet(dev); err != nil {
+		return nil, err
+	}
+	filter, err := afpacketFilter(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var captures []liveCapture
+	closeAll := func() {
+		for _, c := range captures {
+			c.close()
+		}
+	}
+	group := afpacketFanoutGroup(os.Getpid(), dev.Index)
+	for i := 0; i < cfg.AFPacket.Fanout; i++ {
+		tp, err := afpacket.NewTPacket(
+			afpacket.OptInterface(iface),
+			afpacket.OptTPacketVersion(afpacket.TPacketVersion3),
+			afpacket.OptFrameSize(afpacketFrameSize),
+			afpacket.OptBlockSize(cfg.AFPacket.BlockSize),
+			afpacket.OptNumBlocks(cfg.AFPacket.NumBlocks),
+			afpacket.SocketRaw,
+		)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		captures = append(captures, liveCapture{
+			iface:    iface,
+			source:   tp,
+			linkType: layers.LinkTypeEthernet,
+			close:    tp.Close,
+			stats: func() (captureStats, error) {
+				// 读取后内核计数清零, afpacket包内部会累加
+				_, v3, err := tp.SocketStats()
+				return captureStats{received: uint64(v3.Packets()), dropped: uint64(v3.Drops())}, err
+			},
+			setFilter: func(cfg *config) error {
+				filter, err := afpacketFilter(cfg)
+				if err != nil {
+					return err
+				}
+				if err := tp.SetBPF(filter); err != nil {
+					return fmt.Errorf("无法设置BPF过滤器 %q: %w", cfg.BPFFilter, err)
+				}
+				return nil
+			},
+		})
+
+		if err := tp.SetBPF(filter); err != nil {
+			closeAll()
+			return nil, fmt.Errorf("无法设置BPF过滤器 %q: %w", cfg.BPFFilter, err)
+		}
+		if cfg.AFPacket.Fanout > 1 {
+			if err := tp.SetFanout(afpacketFanoutType(cfg.AFPacket.FanoutMode), group); err != nil {
+				closeAll()
+				return nil, fmt.Errorf("无法加入fanout组 %d: %w", group, err)
+			}
+		}
+	}
+
+	if cfg.Promiscuous {
+		fd, err := enablePromiscuous(dev.Index)
+		if err != nil {
+			logEvent(slog.LevelWarn, "capture_error", "无法开启混杂模式",
+				slog.String("interface", iface), slog.String("error", err.Error()))
+		} else {
+			closeSockets := captures[0].close
+			captures[0].close = func() {
+				unix.Close(fd)
+				closeSockets()
+			}
+		}
+	}
+	return captures, nil
+}
+
+// fanout组ID在整个网络命名空间内共享, 由进程号和接口序号的哈希得到
+//
+// 直接相加时不同进程的组会重合(例如进程100的接口3和进程101的接口2),
+// 加入别人的组会使两个进程各自只收到一部分数据包。
+func afpacketFanoutGroup(pid, ifindex int) uint16 {
+	h := fnv.New32a()
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(pid))
+	binary.BigEndian.PutUint64(b[8:], uint64(ifindex))
+	h.Write(b[:])
+	sum := h.Sum32()
+	return uint16(sum>>16) ^ uint16(sum)
+}
+
+// 解析器按以太网帧处理AF_PACKET收到的数据包, 其他链路层类型的接口
+// (例如WireGuard、tun和PPP)没有以太网头部, 解析结果是错的
+func checkEthernet(dev *net.Interface) error {
+	data, err := os.ReadFile(filepath.Join("/sys/class/net", dev.Name, "type"))
+	if err != nil {
+		// 没有sysfs时按硬件地址判断
+		if len(dev.HardwareAddr) == 6 || dev.Flags&net.FlagLoopback != 0 {
+			return nil
+		}
+		return fmt.Errorf("接口 %s 不是以太网接口, AF_PACKET后端只支持以太网, 请使用 capture_backend: pcap", dev.Name)
+	}
+	arphrd, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return fmt.Errorf("无法读取接口 %s 的链路层类型: %w", dev.Name, err)
+	}
+	if !ethernetARPHRD(arphrd) {
+		return fmt.Errorf("接口 %s 的链路层类型为 %d, 不是以太网, AF_PACKET后端只支持以太网, 请使用 capture_backend: pcap", dev.Name, arphrd)
+	}
+	return nil
+}
+
+// 回环接口的数据包也带有全零地址的以太网头部
+func ethernetARPHRD(arphrd int) bool {
+	return arphrd == unix.ARPHRD_ETHER || arphrd == unix.ARPHRD_LOOPBACK
+}
+
+func afpacketFanoutType(mode string) afpacket.FanoutType {
+	switch mode {
+	case "cpu":
+		return afpacket.FanoutCPU
+	case "lb":
+		return afpacket.FanoutLoadBalance
+	}
+	return afpacket.FanoutHash
+}
+
+// 把配置的过滤器编译成套接字上的BPF程序
+//
+// 过滤器同时决定每个数据包复制多少字节, 空过滤器也要编译, 这样snaplen才会生效。
+// AF_PACKET没有pcap的方向设置, 只捕获入站流量时在程序前面加上对数据包类型的判断。
+func afpacketFilter(cfg *config) ([]bpf.RawInstruction, error) {
+	compiled, err := pcap.CompileBPFFilter(layers.LinkTypeEthernet, cfg.SnapLen, cfg.BPFFilter)
+	if err != nil {
+		return nil, fmt.Errorf("无法编译BPF过滤器 %q: %w", cfg.BPFFilter, err)
+	}
+
+	var program []bpf.RawInstruction
+	if cfg.InboundOnly {
+		program, err = bpf.Assemble([]bpf.Instruction{
+			bpf.LoadExtension{Num: bpf.ExtType},
+			bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: unix.PACKET_OUTGOING, SkipTrue: 1},
+			bpf.RetConstant{Val: 0}, // 本机发出的数据包
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, ins := range compiled {
+		program = append(program, bpf.RawInstruction{Op: ins.Code, Jt: ins.Jt, Jf: ins.Jf, K: ins.K})
+	}
+	return program, nil
+}
+
+// 混杂模式: AF_PACKET套接字不会自动开启, 用一个不接收数据的套接字加入混杂组,
+// 该套接字关闭时内核自动恢复接口原来的状态
+func enablePromiscuous(ifindex int) (int, error) {
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, 0)
+	if err != nil {
+		return 0, err
+	}
+	mreq := unix.PacketMreq{Ifindex: int32(ifindex), Type: unix.PACKET_MR_PROMISC}
+	if err := unix.SetsockoptPacketMreq(fd, unix.SOL_PACKET, unix.PACKET_ADD_MEMBERSHIP, &mreq); err != nil {
+		unix.Close(fd)
+		return 0, err
+	}
+	return fd, nil
+}
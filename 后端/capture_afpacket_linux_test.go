@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestAFPacketFanoutGroup(t *testing.T) {
+	if afpacketFanoutGroup(4321, 2) != afpacketFanoutGroup(4321, 2) {
+		t.Fatal("同一个进程和接口应该得到相同的组")
+	}
+	// 进程号与接口序号直接相加时这些组合会得到同一个组
+	seen := make(map[uint16]string)
+	for _, tc := range []struct {
+		pid, ifindex int
+		name         string
+	}{
+		{100, 3, "进程100的接口3"},
+		{101, 2, "进程101的接口2"},
+		{102, 1, "进程102的接口1"},
+		{100, 2, "进程100的接口2"},
+		{65636, 3, "进程65636的接口3"},
+	} {
+		group := afpacketFanoutGroup(tc.pid, tc.ifindex)
+		if other, ok := seen[group]; ok {
+			t.Errorf("%s 与 %s 使用同一个fanout组 %d", tc.name, other, group)
+		}
+		seen[group] = tc.name
+	}
+}
+
+func TestEthernetARPHRD(t *testing.T) {
+	for _, tc := range []struct {
+		arphrd int
+		want   bool
+	}{
+		{unix.ARPHRD_ETHER, true},
+		{unix.ARPHRD_LOOPBACK, true},
+		{unix.ARPHRD_NONE, false}, // WireGuard, tun
+		{unix.ARPHRD_PPP, false},
+		{unix.ARPHRD_IPGRE, false},
+	} {
+		if got := ethernetARPHRD(tc.arphrd); got != tc.want {
+			t.Errorf("ARPHRD %d: %v, 期望 %v", tc.arphrd, got, tc.want)
+		}
+	}
+}
+
+func TestCheckEthernet(t *testing.T) {
+	if lo, err := net.InterfaceByName("lo"); err == nil {
+		if err := checkEthernet(lo); err != nil {
+			t.Errorf("回环接口: %v", err)
+		}
+	}
+	// 没有sysfs条目也没有以太网地址的接口
+	if err := checkEthernet(&net.Interface{Name: "wg-blogguard-test"}); err == nil {
+		t.Error("非以太网接口应该返回错误")
+	}
+	if err := checkEthernet(&net.Interface{Name: "eth-blogguard-test", HardwareAddr: net.HardwareAddr{2, 0, 0, 0, 0, 1}}); err != nil {
+		t.Errorf("带以太网地址的接口: %v", err)
+	}
+}
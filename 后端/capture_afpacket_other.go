@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// AF_PACKET只有Linux内核提供, 其他平台只能使用pcap后端
+func openAFPacket(cfg *config, iface string) ([]liveCapture, error) {
+	return nil, errors.New("afpacket抓包后端只能在Linux上使用")
+}
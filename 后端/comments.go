@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// 评论服务配置
+const (
+	maxCommentLength = 2000 // 单条评论最大字符数
+	maxCommentBody   = 64 << 10
+)
+
+// 前端提交的评论数据
+type commentRequest struct {
+	Content   string `json:"content"`
+	Timestamp string `json:"timestamp"`
+}
+
+// 返回给前端的结果, 前端会调用 response.json() 解析
+type commentResponse struct {
+	Status     string   `json:"status"`
+	Message    string   `json:"message,omitempty"`
+	ReceivedAt string   `json:"receivedAt,omitempty"`
+	Comment    *comment `json:"comment,omitempty"`
+}
+
+// 页面评论列表
+type commentListResponse struct {
+	Status   string    `json:"status"`
+	Page     string    `json:"page"`
+	Comments []comment `json:"comments"`
+}
+
+// 评论HTTP接口
+type commentServer struct {
+	store *commentStore
+}
+
+// 在后台启动评论HTTP服务
+func startCommentServer(addr string, store *commentStore) {
+	s := &commentServer{store: store}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/receive-comment", withCORS(s.handleReceiveComment))
+	mux.HandleFunc("/comments", withCORS(s.handleListComments))
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       10 * time.Second,
+		WriteTimeout:      10 * time.Second,
+	}
+
+	go func() {
+		logEvent(slog.LevelInfo, "comments_started", "评论服务已启动", slog.String("listen", addr))
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logEvent(slog.LevelError, "comments_error", "评论服务异常退出", slog.String("error", err.Error()))
+		}
+	}()
+}
+
+// 页面和评论服务不同源, 需要处理跨域预检请求
+func withCORS(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// 接收并校验前端提交的评论
+func (s *commentServer) handleReceiveComment(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST, OPTIONS")
+		writeJSON(w, http.StatusMethodNotAllowed, commentResponse{Status: "error", Message: "只支持POST请求"})
+		return
+	}
+
+	var req commentRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxCommentBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, commentResponse{Status: "error", Message: "请求格式错误"})
+		return
+	}
+
+	if err := validateComment(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, commentResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	page, body, err := parseCommentPage(req.Content)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, commentResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	c := &comment{
+		Page:      page,
+		Content:   body,
+		Timestamp: req.Timestamp,
+		CreatedAt: time.Now(),
+	}
+	if err := s.store.Add(c); err != nil {
+		logEvent(slog.LevelError, "comments_error", "保存评论失败", slog.String("error", err.Error()))
+		writeJSON(w, http.StatusInternalServerError, commentResponse{Status: "error", Message: "保存评论失败"})
+		return
+	}
+
+	logEvent(slog.LevelInfo, "comment_received", "收到评论",
+		slog.String("page", c.Page), slog.Uint64("id", c.ID), slog.String("content", c.Content))
+	writeJSON(w, http.StatusOK, commentResponse{
+		Status:     "ok",
+		ReceivedAt: c.CreatedAt.Format(time.RFC3339),
+		Comment:    c,
+	})
+}
+
+// 按提交顺序列出某个页面的评论, 例如 GET /comments?page=page3
+func (s *commentServer) handleListComments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET, OPTIONS")
+		writeJSON(w, http.StatusMethodNotAllowed, commentResponse{Status: "error", Message: "只支持GET请求"})
+		return
+	}
+
+	page := r.URL.Query().Get("page")
+	if !validPageName(page) {
+		writeJSON(w, http.StatusBadRequest, commentResponse{Status: "error", Message: "页面标识无效"})
+		return
+	}
+
+	comments, err := s.store.List(page)
+	if err != nil {
+		logEvent(slog.LevelError, "comments_error", "读取评论失败", slog.String("error", err.Error()))
+		writeJSON(w, http.StatusInternalServerError, commentResponse{Status: "error", Message: "读取评论失败"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, commentListResponse{
+		Status:   "ok",
+		Page:     page,
+		Comments: comments,
+	})
+}
+
+// 校验评论内容和时间戳
+func validateComment(req *commentRequest) error {
+	req.Content = strings.TrimSpace(req.Content)
+	if req.Content == "" {
+		return fmt.Errorf("评论内容不能为空")
+	}
+	if !utf8.ValidString(req.Content) {
+		return fmt.Errorf("评论内容编码无效")
+	}
+	if utf8.RuneCountInString(req.Content) > maxCommentLength {
+		return fmt.Errorf("评论内容不能超过%d个字符", maxCommentLength)
+	}
+	if _, err := time.Parse(time.RFC3339, req.Timestamp); err != nil {
+		return fmt.Errorf("时间戳格式错误")
+	}
+	return nil
+}
+
+// 以JSON格式写回响应
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateComment(t *testing.T) {
+	for _, tc := range []struct {
+		content   string
+		timestamp string
+		wantErr   string
+	}{
+		{"page1-----你好", "2026-03-01T12:00:00Z", ""},
+		{"page1-----你好", "2026-03-01T20:00:00+08:00", ""},
+		{"page1-----你好", "2026-03-01T12:00:00.123Z", ""}, // 前端 toISOString() 带毫秒
+		{"  page1-----你好  ", "2026-03-01T12:00:00Z", ""},
+		{"page1-----你好", "", "时间戳格式错误"},
+		{"page1-----你好", "2026-03-01 12:00:00", "时间戳格式错误"},
+		{"page1-----你好", "2026-03-01T12:00:00", "时间戳格式错误"}, // 缺少时区
+		{"page1-----你好", "1709294400", "时间戳格式错误"},
+		{"   ", "2026-03-01T12:00:00Z", "评论内容不能为空"},
+		{"page1-----\xff", "2026-03-01T12:00:00Z", "评论内容编码无效"},
+		{strings.Repeat("字", maxCommentLength+1), "2026-03-01T12:00:00Z", "评论内容不能超过2000个字符"},
+	} {
+		req := commentRequest{Content: tc.content, Timestamp: tc.timestamp}
+		err := validateComment(&req)
+		if tc.wantErr == "" {
+			if err != nil {
+				t.Errorf("%q %q: %v", tc.content, tc.timestamp, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tc.wantErr {
+			t.Errorf("%q %q: 错误 %v, 期望 %q", tc.content, tc.timestamp, err, tc.wantErr)
+		}
+	}
+
+	// 最多2000个字符, 而不是2000个字节
+	req := commentRequest{Content: strings.Repeat("字", maxCommentLength), Timestamp: "2026-03-01T12:00:00Z"}
+	if err := validateComment(&req); err != nil {
+		t.Errorf("2000个汉字: %v", err)
+	}
+}
+
+func TestReceiveComment(t *testing.T) {
+	store, err := openCommentStore(filepath.Join(t.TempDir(), "comments.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	handler := withCORS((&commentServer{store: store}).handleReceiveComment)
+
+	post := func(body string) (*httptest.ResponseRecorder, commentResponse) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodPost, "/receive-comment", strings.NewReader(body)))
+		var resp commentResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("响应不是JSON: %s", rec.Body)
+		}
+		return rec, resp
+	}
+
+	rec, resp := post(`{"content":"page3-----写得很好","timestamp":"2026-03-01T12:00:00.000Z"}`)
+	if rec.Code != http.StatusOK || resp.Status != "ok" || resp.Comment == nil {
+		t.Fatalf("状态 %d: %+v", rec.Code, resp)
+	}
+	if c := resp.Comment; c.ID != 1 || c.Page != "page3" || c.Content != "写得很好" || c.Timestamp != "2026-03-01T12:00:00.000Z" {
+		t.Errorf("保存的评论: %+v", c)
+	}
+	if rec.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Error("缺少跨域响应头")
+	}
+
+	for _, tc := range []struct {
+		body, want string
+	}{
+		{`{"content":"page3-----你好","timestamp":"昨天"}`, "时间戳格式错误"},
+		{`{"content":"page3-----你好"}`, "时间戳格式错误"},
+		{`{"content":"你好","timestamp":"2026-03-01T12:00:00Z"}`, "评论缺少页面标识"},
+		{`{"content":"page3-----你好","timestamp":"2026-03-01T12:00:00Z","author":"x"}`, "请求格式错误"},
+		{`page3-----你好`, "请求格式错误"},
+	} {
+		rec, resp := post(tc.body)
+		if rec.Code != http.StatusBadRequest || resp.Status != "error" || resp.Message != tc.want {
+			t.Errorf("%s: 状态 %d, %+v, 期望 %q", tc.body, rec.Code, resp, tc.want)
+		}
+	}
+
+	// 跨域预检请求和其他方法
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodOptions, "/receive-comment", nil))
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Methods") != "GET, POST, OPTIONS" {
+		t.Errorf("OPTIONS: 状态 %d, %v", rec.Code, rec.Header())
+	}
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/receive-comment", nil))
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "POST, OPTIONS" {
+		t.Errorf("GET: 状态 %d, Allow %q", rec.Code, rec.Header().Get("Allow"))
+	}
+}
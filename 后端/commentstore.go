@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// 前端在评论内容前拼接 "页面名-----" 作为所属页面
+const commentPageSeparator = "-----"
+
+var (
+	commentsBucket = []byte("comments")
+	pageNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+)
+
+// 一条已保存的评论
+type comment struct {
+	ID        uint64    `json:"id"`
+	Page      string    `json:"page"`
+	Content   string    `json:"content"`
+	Timestamp string    `json:"timestamp"` // 前端提交的时间
+	CreatedAt time.Time `json:"createdAt"` // 服务端接收时间
+}
+
+// 基于BoltDB的评论存储, 每个页面一个子bucket
+type commentStore struct {
+	db *bolt.DB
+}
+
+// 打开(或创建)评论数据库文件
+func openCommentStore(path string) (*commentStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(commentsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &commentStore{db: db}, nil
+}
+
+func (s *commentStore) Close() error {
+	return s.db.Close()
+}
+
+// 保存评论, 按页面内自增序号作为键以保持提交顺序
+func (s *commentStore) Add(c *comment) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		page, err := tx.Bucket(commentsBucket).CreateBucketIfNotExists([]byte(c.Page))
+		if err != nil {
+			return err
+		}
+		id, err := page.NextSequence()
+		if err != nil {
+			return err
+		}
+		c.ID = id
+		data, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		return page.Put(itob(id), data)
+	})
+}
+
+// 按提交顺序列出某个页面的评论
+func (s *commentStore) List(page string) ([]comment, error) {
+	comments := []comment{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(commentsBucket).Bucket([]byte(page))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(_, v []byte) error {
+			var c comment
+			if err := json.Unmarshal(v, &c); err != nil {
+				return err
+			}
+			comments = append(comments, c)
+			return nil
+		})
+	})
+	return comments, err
+}
+
+// 解析 "page3-----评论内容" 格式, 返回页面名和评论正文
+func parseCommentPage(content string) (page, body string, err error) {
+	page, body, found := strings.Cut(content, commentPageSeparator)
+	if !found {
+		return "", "", fmt.Errorf("评论缺少页面标识")
+	}
+	if !validPageName(page) {
+		return "", "", fmt.Errorf("页面标识无效")
+	}
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return "", "", fmt.Errorf("评论内容不能为空")
+	}
+	return page, body, nil
+}
+
+func validPageName(page string) bool {
+	return pageNameRegexp.MatchString(page)
+}
+
+// 大端序编码, 保证BoltDB中按数值顺序遍历
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCommentPage(t *testing.T) {
+	for _, tc := range []struct {
+		content    string
+		page, body string
+		wantErr    string
+	}{
+		{"page3-----写得很好", "page3", "写得很好", ""},
+		{"page3-----  写得很好  ", "page3", "写得很好", ""},
+		{"my_post-2-----a-----b", "my_post-2", "a-----b", ""}, // 只按第一个分隔符拆分
+		{"page3------多一个横线", "page3", "-多一个横线", ""},
+		{"写得很好", "", "", "评论缺少页面标识"},
+		{"page3----写得很好", "", "", "评论缺少页面标识"},
+		{"-----写得很好", "", "", "页面标识无效"},
+		{"../etc-----写得很好", "", "", "页面标识无效"},
+		{"page 3-----写得很好", "", "", "页面标识无效"},
+		{"页面-----写得很好", "", "", "页面标识无效"},
+		{strings.Repeat("p", 65) + "-----写得很好", "", "", "页面标识无效"},
+		{"page3-----   ", "", "", "评论内容不能为空"},
+	} {
+		page, body, err := parseCommentPage(tc.content)
+		if tc.wantErr != "" {
+			if err == nil || err.Error() != tc.wantErr {
+				t.Errorf("%q: 错误 %v, 期望 %q", tc.content, err, tc.wantErr)
+			}
+			continue
+		}
+		if err != nil || page != tc.page || body != tc.body {
+			t.Errorf("%q: 得到 %q %q %v, 期望 %q %q", tc.content, page, body, err, tc.page, tc.body)
+		}
+	}
+}
+
+func commentContents(comments []comment) []string {
+	contents := make([]string, len(comments))
+	for i, c := range comments {
+		contents[i] = c.Content
+	}
+	return contents
+}
+
+func TestCommentStoreOrderAfterReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "comments.db")
+	t0 := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	store, err := openCommentStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 超过10条, 确认键按数值而不是字符串排序
+	for i := 0; i < 12; i++ {
+		page := "page1"
+		if i%3 == 2 {
+			page = "page2"
+		}
+		c := &comment{Page: page, Content: string(rune('a' + i)), Timestamp: t0.Format(time.RFC3339), CreatedAt: t0}
+		if err := store.Add(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Close()
+
+	store, err = openCommentStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.Add(&comment{Page: "page1", Content: "m", CreatedAt: t0}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		page string
+		want string
+	}{
+		{"page1", "abdeghjkm"},
+		{"page2", "cfil"},
+		{"page3", ""},
+	} {
+		comments, err := store.List(tc.page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(commentContents(comments), ""); got != tc.want {
+			t.Errorf("%s: 评论顺序 %q, 期望 %q", tc.page, got, tc.want)
+		}
+		// 页面内的序号从1开始连续递增, 重新打开后继续
+		for i, c := range comments {
+			if c.ID != uint64(i+1) || c.Page != tc.page {
+				t.Errorf("%s: 第 %d 条评论 %+v", tc.page, i+1, c)
+			}
+		}
+	}
+}
+
+func TestListComments(t *testing.T) {
+	store, err := openCommentStore(filepath.Join(t.TempDir(), "comments.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.Add(&comment{Page: "page3", Content: "第一条"})
+	store.Add(&comment{Page: "page3", Content: "第二条"})
+	handler := withCORS((&commentServer{store: store}).handleListComments)
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/comments?page=page3", nil))
+	var list commentListResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(commentContents(list.Comments), ","); rec.Code != http.StatusOK || list.Page != "page3" || got != "第一条,第二条" {
+		t.Fatalf("状态 %d: %s", rec.Code, rec.Body)
+	}
+
+	// 没有评论的页面返回空数组而不是null
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/comments?page=page4", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"comments":[]`) {
+		t.Errorf("状态 %d: %s", rec.Code, rec.Body)
+	}
+
+	for _, target := range []string{"/comments", "/comments?page=../page3", "/comments?page=page%203"} {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: 状态 %d, 期望400", target, rec.Code)
+		}
+	}
+}
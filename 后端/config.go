@@ -0,0 +1,540 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"gopkg.in/yaml.v3"
+)
+
+// 监控程序的全部可调参数, 可以来自配置文件, 命令行参数优先
+type config struct {
+	Interfaces  []string `yaml:"interfaces"` // 同时监听的网络接口, 为空时自动选择一个
+	SnapLen     int      `yaml:"snaplen"`
+	Promiscuous bool     `yaml:"promiscuous"`
+	Decoder     string   `yaml:"decoder"`      // fast: 预分配各层的快速解析, full: gopacket完整解析
+	BPFFilter   string   `yaml:"bpf_filter"`   // 内核中的抓包过滤器, 为空时捕获全部流量
+	InboundOnly bool     `yaml:"inbound_only"` // 只捕获发往本机的数据包
+
+	CaptureBackend string         `yaml:"capture_backend"` // pcap, afpacket(仅Linux)
+	AFPacket       afpacketConfig `yaml:"afpacket"`
+
+	MaxPacketsPerSecond float64       `yaml:"max_packets_per_second"`
+	BlockDuration       time.Duration `yaml:"block_duration"`
+	RateWindow          time.Duration `yaml:"rate_window"`
+	RateWindowBuckets   int           `yaml:"rate_window_buckets"`
+	BlockJournal        string        `yaml:"block_journal"`      // 为空时不持久化阻塞列表
+	Allowlist           []string      `yaml:"allowlist"`          // 永不阻塞的IP或CIDR
+	IPv6SourcePrefix    int           `yaml:"ipv6_source_prefix"` // IPv6来源按该前缀长度统计和阻塞
+
+	Pipeline     pipelineConfig    `yaml:"pipeline"`
+	Tracking     trackingConfig    `yaml:"tracking"`
+	Escalation   escalationConfig  `yaml:"escalation"`
+	HeavyHitters heavyHitterConfig `yaml:"heavy_hitters"`
+	Aggregates   []aggregateConfig `yaml:"aggregates"` // 按网段聚合检测分布式泛洪
+	Detectors    detectorConfig    `yaml:"detectors"`
+	Enforcement  enforcementConfig `yaml:"enforcement"`
+	Comments     commentConfig     `yaml:"comments"`
+	Metrics      metricsConfig     `yaml:"metrics"`
+	Log          logConfig         `yaml:"log"`
+	Admin        adminConfig       `yaml:"admin"`
+	Alerts       alertConfig       `yaml:"alerts"`
+}
+
+// AF_PACKET TPACKET_V3抓包后端
+type afpacketConfig struct {
+	BlockSize  int    `yaml:"block_size"`  // 环形缓冲区每块的字节数, 必须是页大小的整数倍
+	NumBlocks  int    `yaml:"num_blocks"`  // 每个套接字的块数
+	Fanout     int    `yaml:"fanout"`      // 每个接口打开的套接字数, 大于1时组成fanout组
+	FanoutMode string `yaml:"fanout_mode"` // hash, cpu, lb
+}
+
+// 数据包处理流水线
+type pipelineConfig struct {
+	Workers   int `yaml:"workers"`    // 处理线程数, 0表示使用CPU核数
+	QueueSize int `yaml:"queue_size"` // 每个处理线程的队列长度
+}
+
+// 统计表的内存上限
+type trackingConfig struct {
+	MaxSources    int           `yaml:"max_sources"`    // 最多同时跟踪的来源数
+	MaxPrefixes   int           `yaml:"max_prefixes"`   // 最多同时跟踪的聚合网段数
+	IdleTimeout   time.Duration `yaml:"idle_timeout"`   // 超过该时间没有数据包的条目被清理
+	SweepInterval time.Duration `yaml:"sweep_interval"` // 后台清理的间隔
+}
+
+// 重复违规时逐级延长阻塞时间
+type escalationConfig struct {
+	Factor         float64       `yaml:"factor"`          // 每次违规阻塞时间乘以该系数, 1表示不延长
+	MaxDuration    time.Duration `yaml:"max_duration"`    // 单次阻塞时间上限
+	Lookback       time.Duration `yaml:"lookback"`        // 超过该时间未再犯则违规次数清零
+	DecayInterval  time.Duration `yaml:"decay_interval"`  // 每经过该时间未违规, 违规次数减一, 0表示不衰减
+	PermanentAfter int           `yaml:"permanent_after"` // 达到该违规次数后永久阻塞, 0表示从不
+}
+
+// 聚合流量监控, 用于发现伪造随机源地址的泛洪
+type heavyHitterConfig struct {
+	Enabled             bool          `yaml:"enabled"`
+	Width               int           `yaml:"width"`                  // count-min sketch每行的计数器数
+	Depth               int           `yaml:"depth"`                  // count-min sketch的行数
+	TopK                int           `yaml:"top_k"`                  // 告警中列出的重流量键数量
+	Window              time.Duration `yaml:"window"`                 // 统计窗口
+	BaselineAlpha       float64       `yaml:"baseline_alpha"`         // 基线指数加权平均的系数
+	AlertMultiplier     float64       `yaml:"alert_multiplier"`       // 全局速率超过基线的倍数时告警
+	MinPacketsPerSecond float64       `yaml:"min_packets_per_second"` // 低于该速率时不告警
+	WarmupWindows       int           `yaml:"warmup_windows"`         // 基线建立前不告警
+}
+
+// 各协议检测器的阈值
+type detectorConfig struct {
+	MaxSYNPerSecond        float64 `yaml:"max_syn_per_second"`
+	MinSYNRatio            float64 `yaml:"min_syn_ratio"`
+	MaxUDPPerPortPerSecond float64 `yaml:"max_udp_per_port_per_second"`
+	MaxTrackedUDPPorts     int     `yaml:"max_tracked_udp_ports"`
+	MaxICMPEchoPerSecond   float64 `yaml:"max_icmp_echo_per_second"`
+	MinDNSResponseSize     int     `yaml:"min_dns_response_size"`
+	MaxDNSBytesPerSecond   float64 `yaml:"max_dns_bytes_per_second"`
+}
+
+// 防火墙配置
+type enforcementConfig struct {
+	Backend string `yaml:"backend"` // nftables, ipset, none
+	DryRun  bool   `yaml:"dry_run"`
+}
+
+// 评论服务配置
+type commentConfig struct {
+	Listen   string `yaml:"listen"`
+	Database string `yaml:"database"`
+}
+
+// Prometheus指标服务配置
+type metricsConfig struct {
+	Listen string `yaml:"listen"` // 为空时不提供指标
+}
+
+// 结构化日志配置
+type logConfig struct {
+	Format string `yaml:"format"` // json, text
+	Level  string `yaml:"level"`  // trace(逐包记录), debug, info, warn, error
+}
+
+// 管理接口配置, 接口没有认证, 只能通过unix套接字或本机回环地址访问
+type adminConfig struct {
+	Socket string `yaml:"socket"` // unix套接字路径, 为空时不监听
+	Listen string `yaml:"listen"` // TCP监听地址, 只能是回环地址, 为空时不监听
+}
+
+// 攻击告警, 同一次攻击中的所有检测合并成开始和结束两条告警
+type alertConfig struct {
+	EpisodeGap time.Duration     `yaml:"episode_gap"` // 超过该时间没有新的检测时认为攻击已结束
+	Sinks      []alertSinkConfig `yaml:"sinks"`
+}
+
+// 一个告警目的地
+type alertSinkConfig struct {
+	Name        string        `yaml:"name"`         // 日志中显示的名称, 默认为类型
+	Type        string        `yaml:"type"`         // webhook(通用JSON), slack, smtp
+	URL         string        `yaml:"url"`          // webhook和slack的地址
+	MinInterval time.Duration `yaml:"min_interval"` // 两条告警的最小间隔, 期间的告警合并为最新的一条
+	SMTP        smtpConfig    `yaml:"smtp"`
+}
+
+type smtpConfig struct {
+	Server   string   `yaml:"server"` // host:port, 服务器支持时自动使用STARTTLS
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+}
+
+// 只在命令行中出现的选项
+type cliOptions struct {
+	configPath     string
+	readFile       string
+	listInterfaces bool
+}
+
+// 默认配置
+func defaultConfig() *config {
+	return &config{
+		SnapLen:     1600,
+		Promiscuous: true,
+		Decoder:     "fast",
+		BPFFilter:   "dst port 80 or dst port 443 or dst port 3000 or udp or icmp or icmp6", // UDP和ICMP供对应的检测器使用
+		InboundOnly: true,
+
+		CaptureBackend: "pcap",
+		AFPacket: afpacketConfig{
+			BlockSize:  1 << 20,
+			NumBlocks:  64,
+			Fanout:     1,
+			FanoutMode: "hash",
+		},
+
+		MaxPacketsPerSecond: 100,
+		BlockDuration:       60 * time.Second,
+		RateWindow:          time.Second,
+		RateWindowBuckets:   10,
+		BlockJournal:        "blocks.journal",
+		IPv6SourcePrefix:    64,
+
+		Pipeline: pipelineConfig{
+			QueueSize: 1024,
+		},
+		Tracking: trackingConfig{
+			MaxSources:    200000,
+			MaxPrefixes:   50000,
+			IdleTimeout:   2 * time.Minute,
+			SweepInterval: 10 * time.Second,
+		},
+		Escalation: escalationConfig{
+			Factor:        2,
+			MaxDuration:   24 * time.Hour,
+			Lookback:      24 * time.Hour,
+			DecayInterval: time.Hour,
+		},
+		Aggregates: []aggregateConfig{
+			{Family: "ipv4", PrefixLen: 24, MaxPacketsPerSecond: 1000},
+			{Family: "ipv6", PrefixLen: 64, MaxPacketsPerSecond: 1000},
+			{Family: "ipv6", PrefixLen: 48, MaxPacketsPerSecond: 5000},
+		},
+		HeavyHitters: heavyHitterConfig{
+			Enabled:             true,
+			Width:               2048,
+			Depth:               4,
+			TopK:                10,
+			Window:              time.Second,
+			BaselineAlpha:       0.05,
+			AlertMultiplier:     5,
+			MinPacketsPerSecond: 5000,
+			WarmupWindows:       30,
+		},
+		Detectors: detectorConfig{
+			MaxSYNPerSecond:        50,
+			MinSYNRatio:            0.8,
+			MaxUDPPerPortPerSecond: 80,
+			MaxTrackedUDPPorts:     64,
+			MaxICMPEchoPerSecond:   20,
+			MinDNSResponseSize:     512,
+			MaxDNSBytesPerSecond:   1 << 16,
+		},
+		Enforcement: enforcementConfig{
+			Backend: "nftables",
+		},
+		Comments: commentConfig{
+			Listen:   ":3000",
+			Database: "comments.db",
+		},
+		Metrics: metricsConfig{
+			Listen: "127.0.0.1:9310",
+		},
+		Log: logConfig{
+			Format: "json",
+			Level:  "info",
+		},
+		Admin: adminConfig{
+			Socket: defaultAdminSocket,
+		},
+		Alerts: alertConfig{
+			EpisodeGap: 5 * time.Minute,
+		},
+	}
+}
+
+// 把命令行参数绑定到配置字段上, 参数的默认值就是当前字段的值
+func newFlagSet(c *config, opts *cliOptions) *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+	fs.StringVar(&opts.configPath, "config", opts.configPath, "YAML配置文件路径")
+	fs.StringVar(&opts.readFile, "read", opts.readFile, "离线分析pcap/pcapng文件, 不监听网卡")
+	fs.BoolVar(&opts.listInterfaces, "list-interfaces", opts.listInterfaces, "列出可以监听的网络接口")
+
+	fs.Var(&stringList{values: &c.Interfaces}, "interface", "监听的网络接口, 可以重复指定, 为空时自动选择一个")
+	fs.IntVar(&c.SnapLen, "snaplen", c.SnapLen, "每个数据包捕获的最大字节数")
+	fs.BoolVar(&c.Promiscuous, "promisc", c.Promiscuous, "是否开启混杂模式")
+	fs.StringVar(&c.Decoder, "decoder", c.Decoder, "数据包解析方式: fast, full")
+	fs.StringVar(&c.BPFFilter, "bpf", c.BPFFilter, "BPF抓包过滤器, 为空时捕获全部流量")
+	fs.BoolVar(&c.InboundOnly, "inbound-only", c.InboundOnly, "只捕获发往本机的数据包")
+	fs.StringVar(&c.CaptureBackend, "capture-backend", c.CaptureBackend, "抓包后端: pcap, afpacket(仅Linux)")
+
+	a := &c.AFPacket
+	fs.IntVar(&a.BlockSize, "afpacket-block-size", a.BlockSize, "afpacket环形缓冲区每块的字节数")
+	fs.IntVar(&a.NumBlocks, "afpacket-blocks", a.NumBlocks, "afpacket每个套接字的块数")
+	fs.IntVar(&a.Fanout, "afpacket-fanout", a.Fanout, "afpacket每个接口的套接字数, 大于1时由内核分流")
+	fs.StringVar(&a.FanoutMode, "afpacket-fanout-mode", a.FanoutMode, "afpacket分流方式: hash, cpu, lb")
+
+	fs.Float64Var(&c.MaxPacketsPerSecond, "max-pps", c.MaxPacketsPerSecond, "每个IP每秒最大数据包数")
+	fs.DurationVar(&c.BlockDuration, "block-duration", c.BlockDuration, "阻塞时间")
+	fs.DurationVar(&c.RateWindow, "rate-window", c.RateWindow, "速率统计的滑动窗口长度")
+	fs.IntVar(&c.RateWindowBuckets, "rate-window-buckets", c.RateWindowBuckets, "滑动窗口切分的桶数")
+	fs.StringVar(&c.BlockJournal, "block-journal", c.BlockJournal, "阻塞日志文件, 为空时重启后不恢复阻塞")
+	fs.IntVar(&c.IPv6SourcePrefix, "ipv6-source-prefix", c.IPv6SourcePrefix, "IPv6来源按该前缀长度统计和阻塞, 128表示按单个地址")
+	fs.Var(&stringList{values: &c.Allowlist}, "allow", "永不阻塞的IP或CIDR, 可以重复指定, 覆盖配置文件中的列表")
+
+	fs.IntVar(&c.Pipeline.Workers, "workers", c.Pipeline.Workers, "处理线程数, 0表示使用CPU核数")
+	fs.IntVar(&c.Pipeline.QueueSize, "queue-size", c.Pipeline.QueueSize, "每个处理线程的队列长度")
+
+	t := &c.Tracking
+	fs.IntVar(&t.MaxSources, "max-sources", t.MaxSources, "最多同时跟踪的来源数")
+	fs.IntVar(&t.MaxPrefixes, "max-prefixes", t.MaxPrefixes, "最多同时跟踪的聚合网段数")
+	fs.DurationVar(&t.IdleTimeout, "idle-timeout", t.IdleTimeout, "超过该时间没有数据包的统计条目被清理")
+	fs.DurationVar(&t.SweepInterval, "sweep-interval", t.SweepInterval, "清理空闲统计条目的间隔")
+
+	e := &c.Escalation
+	fs.Float64Var(&e.Factor, "escalation-factor", e.Factor, "重复违规时阻塞时间的增长系数")
+	fs.DurationVar(&e.MaxDuration, "max-block-duration", e.MaxDuration, "单次阻塞时间上限")
+	fs.DurationVar(&e.Lookback, "offense-lookback", e.Lookback, "超过该时间未再犯则违规次数清零")
+	fs.DurationVar(&e.DecayInterval, "offense-decay", e.DecayInterval, "每经过该时间未违规, 违规次数减一")
+	fs.IntVar(&e.PermanentAfter, "permanent-after", e.PermanentAfter, "达到该违规次数后永久阻塞, 0表示从不")
+
+	h := &c.HeavyHitters
+	fs.BoolVar(&h.Enabled, "heavy-hitters", h.Enabled, "是否启用聚合流量监控")
+	fs.Float64Var(&h.AlertMultiplier, "aggregate-multiplier", h.AlertMultiplier, "全局速率超过基线的倍数时告警")
+	fs.Float64Var(&h.MinPacketsPerSecond, "aggregate-min-pps", h.MinPacketsPerSecond, "全局速率低于该值时不告警")
+
+	d := &c.Detectors
+	fs.Float64Var(&d.MaxSYNPerSecond, "max-syn-pps", d.MaxSYNPerSecond, "每秒SYN(无ACK)包数上限")
+	fs.Float64Var(&d.MinSYNRatio, "min-syn-ratio", d.MinSYNRatio, "判定SYN泛洪所需的SYN占比")
+	fs.Float64Var(&d.MaxUDPPerPortPerSecond, "max-udp-pps", d.MaxUDPPerPortPerSecond, "每个目的端口每秒UDP包数上限")
+	fs.IntVar(&d.MaxTrackedUDPPorts, "max-udp-ports", d.MaxTrackedUDPPorts, "每个IP最多跟踪的UDP端口数")
+	fs.Float64Var(&d.MaxICMPEchoPerSecond, "max-icmp-pps", d.MaxICMPEchoPerSecond, "每秒ICMP回显请求数上限")
+	fs.IntVar(&d.MinDNSResponseSize, "min-dns-size", d.MinDNSResponseSize, "计入DNS放大检测的最小响应大小")
+	fs.Float64Var(&d.MaxDNSBytesPerSecond, "max-dns-bps", d.MaxDNSBytesPerSecond, "每秒收到的大DNS响应字节数上限")
+
+	fs.StringVar(&c.Enforcement.Backend, "firewall", c.Enforcement.Backend, "防火墙后端: nftables, ipset, none")
+	fs.BoolVar(&c.Enforcement.DryRun, "firewall-dry-run", c.Enforcement.DryRun, "只打印防火墙命令而不执行")
+
+	fs.StringVar(&c.Comments.Listen, "comment-listen", c.Comments.Listen, "评论服务监听地址")
+	fs.StringVar(&c.Comments.Database, "comment-db", c.Comments.Database, "评论数据库文件")
+	fs.StringVar(&c.Metrics.Listen, "metrics-listen", c.Metrics.Listen, "Prometheus指标服务监听地址, 为空时不提供")
+	fs.StringVar(&c.Admin.Socket, "admin-socket", c.Admin.Socket, "管理接口unix套接字路径, 为空时不监听")
+	fs.StringVar(&c.Admin.Listen, "admin-listen", c.Admin.Listen, "管理接口TCP监听地址, 只能是回环地址, 为空时不监听")
+	fs.StringVar(&c.Log.Format, "log-format", c.Log.Format, "日志格式: json, text")
+	fs.StringVar(&c.Log.Level, "log-level", c.Log.Level, "日志级别: trace, debug, info, warn, error; trace会记录每个数据包")
+	return fs
+}
+
+// 解析命令行参数和配置文件
+//
+// 先解析一遍参数拿到配置文件路径, 加载配置文件后再解析一遍,
+// 使命令行中显式给出的参数覆盖配置文件中的值。
+func loadConfig(args []string) (*config, *cliOptions, error) {
+	opts := &cliOptions{}
+	c := defaultConfig()
+	if err := newFlagSet(c, opts).Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if opts.configPath != "" {
+		c = defaultConfig()
+		if err := readConfigFile(opts.configPath, c); err != nil {
+			return nil, nil, err
+		}
+		if err := newFlagSet(c, opts).Parse(args); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, nil, fmt.Errorf("配置无效:\n%w", err)
+	}
+	return c, opts, nil
+}
+
+// 可以重复指定的字符串参数, 也接受逗号分隔的多个值
+//
+// 命令行中第一次出现时清空来自配置文件的值, 而不是在其后追加。
+type stringList struct {
+	values *[]string
+	seen   bool
+}
+
+func (l *stringList) String() string {
+	if l == nil || l.values == nil {
+		return ""
+	}
+	return strings.Join(*l.values, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	if !l.seen {
+		*l.values = nil
+		l.seen = true
+	}
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l.values = append(*l.values, v)
+		}
+	}
+	return nil
+}
+
+// 读取YAML配置文件, 未出现的字段保持原值
+func readConfigFile(path string, c *config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("解析配置文件 %s 失败: %w", path, err)
+	}
+	return nil
+}
+
+// 检查配置是否合法, 一次报告所有错误
+func (c *config) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, name := range c.Interfaces {
+		check(!seen[name], "interfaces 中的 %s 重复", name)
+		seen[name] = true
+	}
+	check(c.SnapLen >= 64 && c.SnapLen <= 262144, "snaplen 必须在64到262144之间: %d", c.SnapLen)
+	check(c.Decoder == "fast" || c.Decoder == "full", "decoder 只能是 fast 或 full: %q", c.Decoder)
+	if c.BPFFilter != "" {
+		// 启动前还不知道链路层类型, 按以太网编译检查语法
+		if _, err := pcap.CompileBPFFilter(layers.LinkTypeEthernet, c.SnapLen, c.BPFFilter); err != nil {
+			errs = append(errs, fmt.Errorf("bpf_filter %q 无效: %w", c.BPFFilter, err))
+		}
+	}
+	switch c.CaptureBackend {
+	case "pcap":
+	case "afpacket":
+		check(runtime.GOOS == "linux", "capture_backend afpacket 只能在Linux上使用")
+	default:
+		check(false, "capture_backend 只能是 pcap 或 afpacket: %q", c.CaptureBackend)
+	}
+	a := c.AFPacket
+	check(a.BlockSize > 0 && a.BlockSize%os.Getpagesize() == 0 && a.BlockSize%afpacketFrameSize == 0,
+		"afpacket.block_size 必须是 %d 和页大小 %d 的整数倍: %d", afpacketFrameSize, os.Getpagesize(), a.BlockSize)
+	check(a.NumBlocks > 0, "afpacket.num_blocks 必须大于0")
+	check(a.Fanout >= 1 && a.Fanout <= 64, "afpacket.fanout 必须在1到64之间: %d", a.Fanout)
+	switch a.FanoutMode {
+	case "hash", "cpu", "lb":
+	default:
+		check(false, "afpacket.fanout_mode 只能是 hash, cpu 或 lb: %q", a.FanoutMode)
+	}
+
+	check(c.MaxPacketsPerSecond > 0, "max_packets_per_second 必须大于0")
+	check(c.BlockDuration > 0, "block_duration 必须大于0")
+	check(c.RateWindow > 0, "rate_window 必须大于0")
+	check(c.RateWindowBuckets >= 1 && c.RateWindowBuckets <= 1000, "rate_window_buckets 必须在1到1000之间: %d", c.RateWindowBuckets)
+	check(c.IPv6SourcePrefix >= 1 && c.IPv6SourcePrefix <= 128, "ipv6_source_prefix 必须在1到128之间: %d", c.IPv6SourcePrefix)
+	if _, err := parseAllowlist(c.Allowlist); err != nil {
+		errs = append(errs, fmt.Errorf("allowlist: %w", err))
+	}
+	check(c.RateWindowBuckets < 1 || c.RateWindow/time.Duration(c.RateWindowBuckets) > 0,
+		"rate_window 太短, 无法切分成 %d 个桶", c.RateWindowBuckets)
+
+	check(c.Pipeline.Workers >= 0 && c.Pipeline.Workers <= 1024, "pipeline.workers 必须在0到1024之间: %d", c.Pipeline.Workers)
+	check(c.Pipeline.QueueSize >= 1, "pipeline.queue_size 必须大于0")
+
+	t := c.Tracking
+	check(t.MaxSources > 0, "tracking.max_sources 必须大于0")
+	check(t.MaxPrefixes > 0, "tracking.max_prefixes 必须大于0")
+	check(t.IdleTimeout >= c.RateWindow, "tracking.idle_timeout 不能小于 rate_window")
+	check(t.SweepInterval > 0, "tracking.sweep_interval 必须大于0")
+
+	e := c.Escalation
+	check(e.Factor >= 1, "escalation.factor 不能小于1")
+	check(e.MaxDuration >= c.BlockDuration, "escalation.max_duration 不能小于 block_duration")
+	check(e.Lookback > 0, "escalation.lookback 必须大于0")
+	check(e.DecayInterval >= 0, "escalation.decay_interval 不能为负数")
+	check(e.PermanentAfter >= 0, "escalation.permanent_after 不能为负数")
+
+	for i, agg := range c.Aggregates {
+		maxBits := 128
+		switch agg.Family {
+		case "ipv4":
+			maxBits = 32
+		case "ipv6":
+		default:
+			check(false, "aggregates[%d].family 只能是 ipv4 或 ipv6: %q", i, agg.Family)
+		}
+		check(agg.PrefixLen >= 1 && agg.PrefixLen <= maxBits, "aggregates[%d].prefix_len 必须在1到%d之间: %d", i, maxBits, agg.PrefixLen)
+		check(agg.MaxPacketsPerSecond > 0, "aggregates[%d].max_packets_per_second 必须大于0", i)
+	}
+
+	h := c.HeavyHitters
+	check(h.Width > 0, "heavy_hitters.width 必须大于0")
+	check(h.Depth > 0 && h.Depth <= 16, "heavy_hitters.depth 必须在1到16之间")
+	check(h.TopK > 0, "heavy_hitters.top_k 必须大于0")
+	check(h.Window > 0, "heavy_hitters.window 必须大于0")
+	check(h.BaselineAlpha > 0 && h.BaselineAlpha <= 1, "heavy_hitters.baseline_alpha 必须在(0, 1]之间")
+	check(h.AlertMultiplier > 1, "heavy_hitters.alert_multiplier 必须大于1")
+	check(h.MinPacketsPerSecond >= 0, "heavy_hitters.min_packets_per_second 不能为负数")
+	check(h.WarmupWindows >= 0, "heavy_hitters.warmup_windows 不能为负数")
+
+	d := c.Detectors
+	check(d.MaxSYNPerSecond > 0, "detectors.max_syn_per_second 必须大于0")
+	check(d.MinSYNRatio > 0 && d.MinSYNRatio <= 1, "detectors.min_syn_ratio 必须在(0, 1]之间")
+	check(d.MaxUDPPerPortPerSecond > 0, "detectors.max_udp_per_port_per_second 必须大于0")
+	check(d.MaxTrackedUDPPorts > 0, "detectors.max_tracked_udp_ports 必须大于0")
+	check(d.MaxICMPEchoPerSecond > 0, "detectors.max_icmp_echo_per_second 必须大于0")
+	check(d.MinDNSResponseSize >= 0, "detectors.min_dns_response_size 不能为负数")
+	check(d.MaxDNSBytesPerSecond > 0, "detectors.max_dns_bytes_per_second 必须大于0")
+
+	switch c.Enforcement.Backend {
+	case "nftables", "ipset", "none":
+	default:
+		check(false, "enforcement.backend 只能是 nftables, ipset 或 none: %q", c.Enforcement.Backend)
+	}
+
+	check(c.Comments.Listen != "", "comments.listen 不能为空")
+	check(c.Comments.Database != "", "comments.database 不能为空")
+
+	if c.Admin.Listen != "" {
+		check(loopbackAddr(c.Admin.Listen), "admin.listen 只能是本机回环地址: %q", c.Admin.Listen)
+	}
+
+	check(c.Alerts.EpisodeGap > 0, "alerts.episode_gap 必须大于0")
+	for i, sink := range c.Alerts.Sinks {
+		check(sink.MinInterval >= 0, "alerts.sinks[%d].min_interval 不能为负数", i)
+		switch sink.Type {
+		case "webhook", "slack":
+			u, err := url.Parse(sink.URL)
+			check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+				"alerts.sinks[%d].url 必须是http或https地址: %q", i, sink.URL)
+		case "smtp":
+			_, _, err := net.SplitHostPort(sink.SMTP.Server)
+			check(err == nil, "alerts.sinks[%d].smtp.server 必须是 host:port 格式: %q", i, sink.SMTP.Server)
+			check(sink.SMTP.From != "", "alerts.sinks[%d].smtp.from 不能为空", i)
+			check(len(sink.SMTP.To) > 0, "alerts.sinks[%d].smtp.to 不能为空", i)
+		default:
+			check(false, "alerts.sinks[%d].type 只能是 webhook, slack 或 smtp: %q", i, sink.Type)
+		}
+	}
+
+	switch c.Log.Format {
+	case "json", "text":
+	default:
+		check(false, "log.format 只能是 json 或 text: %q", c.Log.Format)
+	}
+	switch c.Log.Level {
+	case "trace", "debug", "info", "warn", "error":
+	default:
+		check(false, "log.level 只能是 trace, debug, info, warn 或 error: %q", c.Log.Level)
+	}
+
+	return errors.Join(errs...)
+}
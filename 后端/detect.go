@@ -0,0 +1,211 @@
+package main
+
+import (
+	"net/netip"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// 攻击类型, 记录在阻塞事件中
+type attackType string
+
+const (
+	attackFlood            attackType = "flood"             // 通用的数据包速率超限
+	attackSYNFlood         attackType = "syn_flood"         // 大量只有SYN没有ACK的TCP包
+	attackUDPFlood         attackType = "udp_flood"         // 对单个UDP端口的高速率
+	attackICMPFlood        attackType = "icmp_flood"        // ICMP回显请求(ping)泛洪
+	attackDNSAmplification attackType = "dns_amplification" // 未请求的大DNS响应
+)
+
+// 检测结果的单位, 用于打印
+func (t attackType) unit() string {
+	if t == attackDNSAmplification {
+		return "字节/秒"
+	}
+	return "包/秒"
+}
+
+// 从数据包中提取检测需要的字段
+type packetInfo struct {
+	iface    string // 捕获到该数据包的接口, 离线分析时为文件名
+	srcIP    netip.Addr
+	dstIP    netip.Addr
+	protocol layers.IPProtocol
+	length   int
+
+	srcPort uint16
+	dstPort uint16
+	syn     bool // TCP SYN且没有ACK
+	tcp     bool
+	udp     bool
+
+	icmpEcho bool // ICMP回显请求
+
+	dnsResponse bool
+	dnsSize     int
+}
+
+// 解析网络层和传输层, 没有网络层的数据包返回false
+func decodePacket(packet gopacket.Packet, info *packetInfo) bool {
+	networkLayer := packet.NetworkLayer()
+	if networkLayer == nil {
+		return false // 没有网络层信息
+	}
+
+	flow := networkLayer.NetworkFlow()
+	src, ok := netip.AddrFromSlice(flow.Src().Raw())
+	if !ok {
+		return false
+	}
+	info.srcIP = src.Unmap()
+	if dst, ok := netip.AddrFromSlice(flow.Dst().Raw()); ok {
+		info.dstIP = dst.Unmap()
+	}
+	info.length = len(packet.Data())
+
+	switch ip := networkLayer.(type) {
+	case *layers.IPv4:
+		info.protocol = ip.Protocol
+	case *layers.IPv6:
+		info.protocol = ipv6UpperProtocol(packet, ip)
+	}
+
+	if tcp, ok := packet.Layer(layers.LayerTypeTCP).(*layers.TCP); ok {
+		info.tcp = true
+		info.srcPort = uint16(tcp.SrcPort)
+		info.dstPort = uint16(tcp.DstPort)
+		info.syn = tcp.SYN && !tcp.ACK
+	}
+	if udp, ok := packet.Layer(layers.LayerTypeUDP).(*layers.UDP); ok {
+		info.udp = true
+		info.srcPort = uint16(udp.SrcPort)
+		info.dstPort = uint16(udp.DstPort)
+		if dns, ok := packet.Layer(layers.LayerTypeDNS).(*layers.DNS); ok && dns.QR {
+			info.dnsResponse = true
+			info.dnsSize = len(udp.Payload)
+		}
+	}
+	if icmp, ok := packet.Layer(layers.LayerTypeICMPv4).(*layers.ICMPv4); ok {
+		info.icmpEcho = icmp.TypeCode.Type() == layers.ICMPv4TypeEchoRequest
+	}
+	if icmp, ok := packet.Layer(layers.LayerTypeICMPv6).(*layers.ICMPv6); ok {
+		info.icmpEcho = icmp.TypeCode.Type() == layers.ICMPv6TypeEchoRequest
+	}
+	return true
+}
+
+// 用于跟踪每个源IP的数据包速率, 各协议的窗口在第一次用到时才分配
+type ipStats struct {
+	packets  *slidingWindow
+	tcpSYN   *slidingWindow
+	tcpOther *slidingWindow
+	udpPorts map[uint16]*slidingWindow
+	icmpEcho *slidingWindow
+	dnsBytes *slidingWindow
+	lastSeen time.Time
+}
+
+func newIPStats(cfg *config) *ipStats {
+	return &ipStats{packets: newRateWindow(cfg)}
+}
+
+func newRateWindow(cfg *config) *slidingWindow {
+	return newSlidingWindow(cfg.RateWindow, cfg.RateWindowBuckets)
+}
+
+// 记录一个数据包并依次运行各协议检测器,
+// 返回第一个触发的攻击类型和观察到的速率
+func (s *ipStats) observe(cfg *config, info *packetInfo, now time.Time) (attackType, float64, bool) {
+	s.lastSeen = now
+	s.packets.Add(now, 1)
+
+	if info.tcp {
+		if t, rate, hit := s.observeTCP(cfg, info, now); hit {
+			return t, rate, true
+		}
+	}
+	if info.udp {
+		if t, rate, hit := s.observeUDP(cfg, info, now); hit {
+			return t, rate, true
+		}
+	}
+	if info.icmpEcho {
+		if s.icmpEcho == nil {
+			s.icmpEcho = newRateWindow(cfg)
+		}
+		s.icmpEcho.Add(now, 1)
+		if rate := s.icmpEcho.Rate(now); rate > cfg.Detectors.MaxICMPEchoPerSecond {
+			return attackICMPFlood, rate, true
+		}
+	}
+
+	// 最后检查与协议无关的总速率
+	if rate := s.packets.Rate(now); rate > cfg.MaxPacketsPerSecond {
+		return attackFlood, rate, true
+	}
+	return "", 0, false
+}
+
+// SYN泛洪: SYN速率超限且几乎没有完成握手的后续报文
+func (s *ipStats) observeTCP(cfg *config, info *packetInfo, now time.Time) (attackType, float64, bool) {
+	if s.tcpSYN == nil {
+		s.tcpSYN = newRateWindow(cfg)
+		s.tcpOther = newRateWindow(cfg)
+	}
+	if !info.syn {
+		s.tcpOther.Add(now, 1)
+		return "", 0, false
+	}
+
+	s.tcpSYN.Add(now, 1)
+	synCount := s.tcpSYN.Count(now)
+	ratio := synCount / (synCount + s.tcpOther.Count(now))
+	if rate := s.tcpSYN.Rate(now); rate > cfg.Detectors.MaxSYNPerSecond && ratio >= cfg.Detectors.MinSYNRatio {
+		return attackSYNFlood, rate, true
+	}
+	return "", 0, false
+}
+
+// UDP泛洪按目的端口统计, DNS放大按收到的大响应字节数统计
+func (s *ipStats) observeUDP(cfg *config, info *packetInfo, now time.Time) (attackType, float64, bool) {
+	if info.dnsResponse && info.dnsSize >= cfg.Detectors.MinDNSResponseSize {
+		if s.dnsBytes == nil {
+			s.dnsBytes = newRateWindow(cfg)
+		}
+		s.dnsBytes.Add(now, uint64(info.dnsSize))
+		if rate := s.dnsBytes.Rate(now); rate > cfg.Detectors.MaxDNSBytesPerSecond {
+			return attackDNSAmplification, rate, true
+		}
+	}
+
+	if s.udpPorts == nil {
+		s.udpPorts = make(map[uint16]*slidingWindow)
+	}
+	window, ok := s.udpPorts[info.dstPort]
+	if !ok {
+		if len(s.udpPorts) >= cfg.Detectors.MaxTrackedUDPPorts {
+			s.pruneUDPPorts(now)
+			if len(s.udpPorts) >= cfg.Detectors.MaxTrackedUDPPorts {
+				return "", 0, false // 端口太分散, 交给总速率检测
+			}
+		}
+		window = newRateWindow(cfg)
+		s.udpPorts[info.dstPort] = window
+	}
+	window.Add(now, 1)
+	if rate := window.Rate(now); rate > cfg.Detectors.MaxUDPPerPortPerSecond {
+		return attackUDPFlood, rate, true
+	}
+	return "", 0, false
+}
+
+// 删除窗口内已经没有数据包的端口
+func (s *ipStats) pruneUDPPorts(now time.Time) {
+	for port, window := range s.udpPorts {
+		if window.Empty(now) {
+			delete(s.udpPorts, port)
+		}
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"net/netip"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 防火墙表名, ipset后端用作集合名前缀
+const firewallTable = "blogguard"
+
+// 执行外部命令的抽象, 可以替换成假执行器在没有root权限时测试
+type commandRunner interface {
+	Run(name string, args ...string) error
+	// 执行命令并把 input 写入其标准输入, 用于 nft -f - 和 ipset restore 批量执行
+	RunInput(input string, name string, args ...string) error
+}
+
+// 真正执行系统命令
+type execRunner struct{}
+
+func (execRunner) Run(name string, args ...string) error {
+	return execRunner{}.RunInput("", name, args...)
+}
+
+func (execRunner) RunInput(input string, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	if input != "" {
+		cmd.Stdin = strings.NewReader(input)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %v: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// 只打印将要执行的命令, 不修改系统防火墙
+type dryRunRunner struct{}
+
+func (dryRunRunner) Run(name string, args ...string) error {
+	return dryRunRunner{}.RunInput("", name, args...)
+}
+
+func (dryRunRunner) RunInput(input string, name string, args ...string) error {
+	attrs := []slog.Attr{slog.String("command", name+" "+strings.Join(args, " "))}
+	if input != "" {
+		attrs = append(attrs, slog.String("input", input))
+	}
+	logEvent(slog.LevelInfo, "firewall_dry_run", "dry-run, 未执行防火墙命令", attrs...)
+	return nil
+}
+
+// 在内核中真正丢弃被阻塞来源的数据包
+type enforcer interface {
+	// 创建表/集合等基础设施, 可以重复调用
+	Setup() error
+	// 按顺序执行一批阻塞和解除阻塞操作, 返回其中失败的操作的错误
+	Apply(actions []firewallAction) error
+}
+
+// 根据名称创建防火墙后端
+func newEnforcer(backend string, runner commandRunner) (enforcer, error) {
+	switch backend {
+	case "nftables":
+		return &nftEnforcer{runner: runner, table: firewallTable}, nil
+	case "ipset":
+		return &ipsetEnforcer{runner: runner, setPrefix: firewallTable}, nil
+	case "none":
+		return noopEnforcer{}, nil
+	}
+	return nil, fmt.Errorf("未知的防火墙后端: %s", backend)
+}
+
+// 不做任何事, 只在用户态忽略数据包
+type noopEnforcer struct{}
+
+func (noopEnforcer) Setup() error                 { return nil }
+func (noopEnforcer) Apply([]firewallAction) error { return nil }
+
+// nftables后端: 在带超时的集合中维护被阻塞的地址
+//
+// 集合没有 auto-merge, 一个网段不能加入已经包含其中单个地址的集合。
+// 单个地址放在 blocked4/blocked6 中, 每种前缀长度的网段使用单独的集合
+// (例如 blocked4_24、blocked6_64), 同一集合中的元素长度相同, 不会互相重叠。
+type nftEnforcer struct {
+	runner    commandRunner
+	table     string
+	installed map[string]bool // 本次运行中加入集合且还没有删除的元素, 只由防火墙goroutine访问
+	sets      map[string]bool // 本次运行中已经创建并添加丢弃规则的网段集合
+}
+
+func (e *nftEnforcer) Setup() error {
+	// 清空链后网段集合的丢弃规则需要在下次使用时重新添加
+	e.sets = make(map[string]bool)
+	steps := [][]string{
+		{"add", "table", "inet", e.table},
+		{"add", "set", "inet", e.table, "blocked4", nftSetSpec("ipv4_addr")},
+		{"add", "set", "inet", e.table, "blocked6", nftSetSpec("ipv6_addr")},
+		// 在prerouting的raw优先级丢弃, 同时覆盖本机nginx和docker转发的流量
+		{"add", "chain", "inet", e.table, "prerouting", "{ type filter hook prerouting priority raw; policy accept; }"},
+		{"flush", "chain", "inet", e.table, "prerouting"},
+		{"add", "rule", "inet", e.table, "prerouting", "ip", "saddr", "@blocked4", "drop"},
+		{"add", "rule", "inet", e.table, "prerouting", "ip6", "saddr", "@blocked6", "drop"},
+	}
+	for _, args := range steps {
+		if err := e.runner.Run("nft", args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nft脚本中的一行, commit 在这一行生效后更新 installed 和 sets
+type nftCommand struct {
+	line   string
+	commit func()
+}
+
+// 把一批操作写成一个nft脚本, 用一个 nft -f - 进程执行
+//
+// 脚本是原子执行的, 其中一条失败(例如删除已经被内核超时清除的元素)会使整批失败,
+// 这时改为逐条执行, 删除元素的失败不算错误。
+// 只记录实际生效的命令, 创建失败的集合和元素在下次用到时重新添加。
+func (e *nftEnforcer) Apply(actions []firewallAction) error {
+	if e.installed == nil {
+		e.installed = make(map[string]bool)
+	}
+	if e.sets == nil {
+		e.sets = make(map[string]bool)
+	}
+	// 生成脚本时假设前面的命令都会成功, 整批失败时恢复到执行前的状态
+	installed, sets := maps.Clone(e.installed), maps.Clone(e.sets)
+
+	var errs []error
+	var script []nftCommand
+	for _, action := range actions {
+		cmds, err := e.commands(action)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, cmd := range cmds {
+			cmd.commit()
+		}
+		script = append(script, cmds...)
+	}
+	if len(script) == 0 {
+		return errors.Join(errs...)
+	}
+
+	lines := make([]string, len(script))
+	for i, cmd := range script {
+		lines[i] = cmd.line
+	}
+	if err := e.runner.RunInput(strings.Join(lines, "\n")+"\n", "nft", "-f", "-"); err != nil {
+		e.installed, e.sets = installed, sets
+		for _, cmd := range script {
+			err := e.runner.RunInput(cmd.line+"\n", "nft", "-f", "-")
+			// 删除失败说明元素已经不在集合中
+			if err == nil || strings.HasPrefix(cmd.line, "delete ") {
+				cmd.commit()
+			} else {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// 一个操作对应的nft命令, 不修改 installed 和 sets
+func (e *nftEnforcer) commands(action firewallAction) ([]nftCommand, error) {
+	set, family, err := e.setFor(action.target)
+	if err != nil {
+		return nil, err
+	}
+
+	var cmds []nftCommand
+	// 第一次用到某个网段集合时创建集合并添加丢弃规则
+	if action.block && set != "blocked4" && set != "blocked6" && !e.sets[set] {
+		addrType, match := "ipv4_addr", "ip"
+		if family == "6" {
+			addrType, match = "ipv6_addr", "ip6"
+		}
+		cmds = append(cmds,
+			nftCommand{fmt.Sprintf("add set inet %s %s %s", e.table, set, nftSetSpec(addrType)), func() {}},
+			nftCommand{fmt.Sprintf("add rule inet %s prerouting %s saddr @%s drop", e.table, match, set), func() { e.sets[set] = true }},
+		)
+	}
+	del := nftCommand{
+		fmt.Sprintf("delete element inet %s %s { %s }", e.table, set, action.target),
+		func() { delete(e.installed, action.target) },
+	}
+	if !action.block {
+		return append(cmds, del), nil
+	}
+
+	// 已存在的元素不会刷新超时, 先删除再添加
+	if e.installed[action.target] {
+		cmds = append(cmds, del)
+	}
+	element := action.target
+	if action.duration > 0 {
+		element += " timeout " + nftTimeout(action.duration)
+	}
+	return append(cmds, nftCommand{
+		fmt.Sprintf("add element inet %s %s { %s }", e.table, set, element),
+		func() { e.installed[action.target] = true },
+	}), nil
+}
+
+// 目标所在的集合和地址族("4"或"6"), 单个地址和 /32、/128 放在主机集合中
+func (e *nftEnforcer) setFor(target string) (string, string, error) {
+	v4, err := isIPv4Target(target)
+	if err != nil {
+		return "", "", err
+	}
+	family, hostBits := "6", 128
+	if v4 {
+		family, hostBits = "4", 32
+	}
+	if prefix, err := netip.ParsePrefix(target); err == nil && prefix.Bits() < hostBits {
+		return fmt.Sprintf("blocked%s_%d", family, prefix.Bits()), family, nil
+	}
+	return "blocked" + family, family, nil
+}
+
+// 带超时的区间集合定义
+func nftSetSpec(addrType string) string {
+	return "{ type " + addrType + "; flags interval, timeout; }"
+}
+
+// ipset + iptables后端, 用于没有nftables的旧系统
+type ipsetEnforcer struct {
+	runner    commandRunner
+	setPrefix string
+}
+
+func (e *ipsetEnforcer) Setup() error {
+	for _, family := range []struct{ set, inet, iptables string }{
+		{e.setPrefix + "4", "inet", "iptables"},
+		{e.setPrefix + "6", "inet6", "ip6tables"},
+	} {
+		if err := e.runner.Run("ipset", "create", family.set, "hash:net", "family", family.inet, "timeout", "0", "-exist"); err != nil {
+			return err
+		}
+		rule := []string{"PREROUTING", "-m", "set", "--match-set", family.set, "src", "-j", "DROP"}
+		if e.runner.Run(family.iptables, append([]string{"-t", "raw", "-C"}, rule...)...) == nil {
+			continue // 规则已存在
+		}
+		if err := e.runner.Run(family.iptables, append([]string{"-t", "raw", "-I"}, rule...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 用一个 ipset restore 进程执行一批操作, -exist 使重复添加刷新超时、删除不存在的条目不报错
+func (e *ipsetEnforcer) Apply(actions []firewallAction) error {
+	var errs []error
+	var lines []string
+	for _, action := range actions {
+		set, err := e.setFor(action.target)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !action.block {
+			lines = append(lines, fmt.Sprintf("del %s %s", set, action.target))
+			continue
+		}
+		timeout := "0" // 0 表示永不过期
+		if action.duration > 0 {
+			timeout = fmt.Sprint(int64(math.Ceil(action.duration.Seconds())))
+		}
+		lines = append(lines, fmt.Sprintf("add %s %s timeout %s", set, action.target, timeout))
+	}
+	if len(lines) > 0 {
+		if err := e.runner.RunInput(strings.Join(lines, "\n")+"\n", "ipset", "-exist", "restore"); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (e *ipsetEnforcer) setFor(target string) (string, error) {
+	v4, err := isIPv4Target(target)
+	if err != nil {
+		return "", err
+	}
+	if v4 {
+		return e.setPrefix + "4", nil
+	}
+	return e.setPrefix + "6", nil
+}
+
+// 判断阻塞目标(IP或CIDR)是否为IPv4
+func isIPv4Target(target string) (bool, error) {
+	if prefix, err := netip.ParsePrefix(target); err == nil {
+		return prefix.Addr().Unmap().Is4(), nil
+	}
+	addr, err := netip.ParseAddr(target)
+	if err != nil {
+		return false, fmt.Errorf("无效的阻塞目标: %s", target)
+	}
+	return addr.Unmap().Is4(), nil
+}
+
+// nft的超时参数, 向上取整到秒
+func nftTimeout(d time.Duration) string {
+	return fmt.Sprintf("%ds", int64(math.Ceil(d.Seconds())))
+}
+
+// 一次待执行的防火墙操作
+type firewallAction struct {
+	block    bool
+	target   string
+	duration time.Duration
+}
+
+// 防火墙操作需要执行外部命令, 放到单独的goroutine中按顺序执行,
+// 避免拖慢数据包处理
+//
+// 操作从不丢弃: 调用方只把操作追加到待执行列表, 防火墙goroutine每次取走全部
+// 待执行的操作批量执行, 攻击期间检测结果再多也只需要少量外部命令。
+type firewall struct {
+	backend enforcer
+	mu      sync.Mutex
+	pending []firewallAction
+	wake    chan struct{} // 有待执行的操作, 容量为1
+}
+
+func newFirewall(backend enforcer) *firewall {
+	f := &firewall{
+		backend: backend,
+		wake:    make(chan struct{}, 1),
+	}
+	go f.run()
+	return f
+}
+
+func (f *firewall) Block(target string, duration time.Duration) {
+	f.enqueue(firewallAction{block: true, target: target, duration: duration})
+}
+
+func (f *firewall) Unblock(target string) {
+	f.enqueue(firewallAction{target: target})
+}
+
+func (f *firewall) enqueue(action firewallAction) {
+	f.mu.Lock()
+	f.pending = append(f.pending, action)
+	f.mu.Unlock()
+	select {
+	case f.wake <- struct{}{}:
+	default: // 防火墙goroutine已经会取走这个操作
+	}
+}
+
+func (f *firewall) run() {
+	for range f.wake {
+		f.mu.Lock()
+		batch := f.pending
+		f.pending = nil
+		f.mu.Unlock()
+		if len(batch) == 0 {
+			continue
+		}
+		if err := f.backend.Apply(batch); err != nil {
+			logEvent(slog.LevelError, "firewall_error", "防火墙操作失败",
+				slog.Int("actions", len(batch)), slog.String("error", err.Error()))
+		}
+	}
+}
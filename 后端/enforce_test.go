@@ -0,0 +1,329 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 记录执行过的命令, 不修改系统防火墙
+type recordingRunner struct {
+	commands []string
+	fail     func(cmd string) error // 非nil时决定某条命令是否失败
+}
+
+func (r *recordingRunner) Run(name string, args ...string) error {
+	return r.RunInput("", name, args...)
+}
+
+func (r *recordingRunner) RunInput(input string, name string, args ...string) error {
+	cmd := strings.Join(append([]string{name}, args...), " ")
+	if input != "" {
+		cmd += " <<" + strings.TrimSuffix(input, "\n")
+	}
+	r.commands = append(r.commands, cmd)
+	if r.fail != nil {
+		return r.fail(cmd)
+	}
+	return nil
+}
+
+func (r *recordingRunner) take() []string {
+	cmds := r.commands
+	r.commands = nil
+	return cmds
+}
+
+func assertCommands(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("命令不符\n得到:\n  %s\n期望:\n  %s", strings.Join(got, "\n  "), strings.Join(want, "\n  "))
+	}
+}
+
+func TestNftSetup(t *testing.T) {
+	runner := &recordingRunner{}
+	e, _ := newEnforcer("nftables", runner)
+	if err := e.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	assertCommands(t, runner.take(),
+		"nft add table inet blogguard",
+		"nft add set inet blogguard blocked4 { type ipv4_addr; flags interval, timeout; }",
+		"nft add set inet blogguard blocked6 { type ipv6_addr; flags interval, timeout; }",
+		"nft add chain inet blogguard prerouting { type filter hook prerouting priority raw; policy accept; }",
+		"nft flush chain inet blogguard prerouting",
+		"nft add rule inet blogguard prerouting ip saddr @blocked4 drop",
+		"nft add rule inet blogguard prerouting ip6 saddr @blocked6 drop",
+	)
+}
+
+func TestNftApply(t *testing.T) {
+	runner := &recordingRunner{}
+	e, _ := newEnforcer("nftables", runner)
+
+	err := e.Apply([]firewallAction{
+		{block: true, target: "203.0.113.7", duration: 90 * time.Second},
+		{block: true, target: "198.51.100.1"},
+		{block: true, target: "2001:db8::1", duration: 1500 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertCommands(t, runner.take(),
+		"nft -f - <<add element inet blogguard blocked4 { 203.0.113.7 timeout 90s }\n"+
+			"add element inet blogguard blocked4 { 198.51.100.1 }\n"+
+			"add element inet blogguard blocked6 { 2001:db8::1 timeout 2s }",
+	)
+
+	// 再次阻塞已有元素时先删除以刷新超时, 解除阻塞只删除
+	err = e.Apply([]firewallAction{
+		{block: true, target: "203.0.113.7", duration: time.Minute},
+		{target: "2001:db8::1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertCommands(t, runner.take(),
+		"nft -f - <<delete element inet blogguard blocked4 { 203.0.113.7 }\n"+
+			"add element inet blogguard blocked4 { 203.0.113.7 timeout 60s }\n"+
+			"delete element inet blogguard blocked6 { 2001:db8::1 }",
+	)
+}
+
+func TestNftApplyRetriesLineByLine(t *testing.T) {
+	// 元素已经被内核超时清除, 删除失败导致整批失败
+	runner := &recordingRunner{fail: func(cmd string) error {
+		if strings.Contains(cmd, "delete element") {
+			return errors.New("No such file or directory")
+		}
+		return nil
+	}}
+	e, _ := newEnforcer("nftables", runner)
+
+	err := e.Apply([]firewallAction{
+		{target: "203.0.113.7"},
+		{block: true, target: "203.0.113.8"},
+	})
+	if err != nil {
+		t.Fatalf("删除失败不应该返回错误: %v", err)
+	}
+	assertCommands(t, runner.take(),
+		"nft -f - <<delete element inet blogguard blocked4 { 203.0.113.7 }\n"+
+			"add element inet blogguard blocked4 { 203.0.113.8 }",
+		"nft -f - <<delete element inet blogguard blocked4 { 203.0.113.7 }",
+		"nft -f - <<add element inet blogguard blocked4 { 203.0.113.8 }",
+	)
+}
+
+func TestNftApplyFailedSetIsRecreated(t *testing.T) {
+	// 创建网段集合失败(例如暂时无法加载内核模块), 整批和逐条执行都失败
+	failing := true
+	runner := &recordingRunner{fail: func(cmd string) error {
+		if failing && strings.Contains(cmd, "blocked4_24") {
+			return errors.New("Could not process rule: No such file or directory")
+		}
+		return nil
+	}}
+	e, _ := newEnforcer("nftables", runner)
+
+	err := e.Apply([]firewallAction{
+		{block: true, target: "203.0.113.0/24", duration: time.Minute},
+		{block: true, target: "198.51.100.1"},
+	})
+	if err == nil {
+		t.Fatal("创建集合失败应该返回错误")
+	}
+	assertCommands(t, runner.take(),
+		"nft -f - <<add set inet blogguard blocked4_24 { type ipv4_addr; flags interval, timeout; }\n"+
+			"add rule inet blogguard prerouting ip saddr @blocked4_24 drop\n"+
+			"add element inet blogguard blocked4_24 { 203.0.113.0/24 timeout 60s }\n"+
+			"add element inet blogguard blocked4 { 198.51.100.1 }",
+		"nft -f - <<add set inet blogguard blocked4_24 { type ipv4_addr; flags interval, timeout; }",
+		"nft -f - <<add rule inet blogguard prerouting ip saddr @blocked4_24 drop",
+		"nft -f - <<add element inet blogguard blocked4_24 { 203.0.113.0/24 timeout 60s }",
+		"nft -f - <<add element inet blogguard blocked4 { 198.51.100.1 }",
+	)
+
+	// 下次阻塞时重新创建集合和规则; 没有加入集合的网段不需要先删除,
+	// 逐条执行时成功的 198.51.100.1 已经记录, 再次阻塞时先删除以刷新超时
+	failing = false
+	err = e.Apply([]firewallAction{
+		{block: true, target: "203.0.113.0/24", duration: time.Minute},
+		{block: true, target: "198.51.100.1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertCommands(t, runner.take(),
+		"nft -f - <<add set inet blogguard blocked4_24 { type ipv4_addr; flags interval, timeout; }\n"+
+			"add rule inet blogguard prerouting ip saddr @blocked4_24 drop\n"+
+			"add element inet blogguard blocked4_24 { 203.0.113.0/24 timeout 60s }\n"+
+			"delete element inet blogguard blocked4 { 198.51.100.1 }\n"+
+			"add element inet blogguard blocked4 { 198.51.100.1 }",
+	)
+}
+
+func TestNftApplyInvalidTarget(t *testing.T) {
+	runner := &recordingRunner{}
+	e, _ := newEnforcer("nftables", runner)
+
+	err := e.Apply([]firewallAction{
+		{block: true, target: "not-an-ip"},
+		{block: true, target: "203.0.113.7"},
+	})
+	if err == nil {
+		t.Fatal("无效目标应该返回错误")
+	}
+	assertCommands(t, runner.take(),
+		"nft -f - <<add element inet blogguard blocked4 { 203.0.113.7 }",
+	)
+}
+
+func TestNftHostThenPrefix(t *testing.T) {
+	runner := &recordingRunner{}
+	e, _ := newEnforcer("nftables", runner)
+	if err := e.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	runner.take()
+
+	// 分布式攻击: 先阻塞单个地址, 之后整个网段超过聚合阈值
+	for _, batch := range [][]firewallAction{
+		{{block: true, target: "203.0.113.7", duration: time.Minute}},
+		{{block: true, target: "203.0.113.0/24", duration: time.Minute}},
+		{{block: true, target: "2001:db8:1:2::/64", duration: time.Minute}},
+		{{block: true, target: "2001:db8:1::/48", duration: time.Minute}},
+		{{block: true, target: "203.0.113.0/24", duration: time.Minute}, {block: true, target: "198.51.100.9/32"}},
+	} {
+		if err := e.Apply(batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 网段放在按前缀长度区分的集合中, 不会与已有的单个地址重叠
+	assertCommands(t, runner.take(),
+		"nft -f - <<add element inet blogguard blocked4 { 203.0.113.7 timeout 60s }",
+		"nft -f - <<add set inet blogguard blocked4_24 { type ipv4_addr; flags interval, timeout; }\n"+
+			"add rule inet blogguard prerouting ip saddr @blocked4_24 drop\n"+
+			"add element inet blogguard blocked4_24 { 203.0.113.0/24 timeout 60s }",
+		"nft -f - <<add set inet blogguard blocked6_64 { type ipv6_addr; flags interval, timeout; }\n"+
+			"add rule inet blogguard prerouting ip6 saddr @blocked6_64 drop\n"+
+			"add element inet blogguard blocked6_64 { 2001:db8:1:2::/64 timeout 60s }",
+		"nft -f - <<add set inet blogguard blocked6_48 { type ipv6_addr; flags interval, timeout; }\n"+
+			"add rule inet blogguard prerouting ip6 saddr @blocked6_48 drop\n"+
+			"add element inet blogguard blocked6_48 { 2001:db8:1::/48 timeout 60s }",
+		"nft -f - <<delete element inet blogguard blocked4_24 { 203.0.113.0/24 }\n"+
+			"add element inet blogguard blocked4_24 { 203.0.113.0/24 timeout 60s }\n"+
+			"add element inet blogguard blocked4 { 198.51.100.9/32 }",
+	)
+
+	// 重新Setup清空了链, 网段集合的规则需要重新添加
+	if err := e.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	runner.take()
+	if err := e.Apply([]firewallAction{{block: true, target: "192.0.2.0/24"}}); err != nil {
+		t.Fatal(err)
+	}
+	assertCommands(t, runner.take(),
+		"nft -f - <<add set inet blogguard blocked4_24 { type ipv4_addr; flags interval, timeout; }\n"+
+			"add rule inet blogguard prerouting ip saddr @blocked4_24 drop\n"+
+			"add element inet blogguard blocked4_24 { 192.0.2.0/24 }",
+	)
+}
+
+func TestIpsetSetup(t *testing.T) {
+	// 检查规则时返回错误, 表示规则还不存在
+	runner := &recordingRunner{fail: func(cmd string) error {
+		if strings.Contains(cmd, " -C ") {
+			return errors.New("no rule")
+		}
+		return nil
+	}}
+	e, _ := newEnforcer("ipset", runner)
+	if err := e.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	assertCommands(t, runner.take(),
+		"ipset create blogguard4 hash:net family inet timeout 0 -exist",
+		"iptables -t raw -C PREROUTING -m set --match-set blogguard4 src -j DROP",
+		"iptables -t raw -I PREROUTING -m set --match-set blogguard4 src -j DROP",
+		"ipset create blogguard6 hash:net family inet6 timeout 0 -exist",
+		"ip6tables -t raw -C PREROUTING -m set --match-set blogguard6 src -j DROP",
+		"ip6tables -t raw -I PREROUTING -m set --match-set blogguard6 src -j DROP",
+	)
+
+	// 规则已存在时不重复插入
+	runner.fail = nil
+	if err := e.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	assertCommands(t, runner.take(),
+		"ipset create blogguard4 hash:net family inet timeout 0 -exist",
+		"iptables -t raw -C PREROUTING -m set --match-set blogguard4 src -j DROP",
+		"ipset create blogguard6 hash:net family inet6 timeout 0 -exist",
+		"ip6tables -t raw -C PREROUTING -m set --match-set blogguard6 src -j DROP",
+	)
+}
+
+func TestIpsetApply(t *testing.T) {
+	runner := &recordingRunner{}
+	e, _ := newEnforcer("ipset", runner)
+
+	err := e.Apply([]firewallAction{
+		{block: true, target: "203.0.113.7", duration: 90 * time.Second},
+		{block: true, target: "198.51.100.0/24"},
+		{block: true, target: "2001:db8::/64", duration: 1500 * time.Millisecond},
+		{target: "203.0.113.7"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertCommands(t, runner.take(),
+		"ipset -exist restore <<add blogguard4 203.0.113.7 timeout 90\n"+
+			"add blogguard4 198.51.100.0/24 timeout 0\n"+
+			"add blogguard6 2001:db8::/64 timeout 2\n"+
+			"del blogguard4 203.0.113.7",
+	)
+}
+
+// 阻塞的enforcer, 用来在执行第一批时积压后续操作
+type gatedEnforcer struct {
+	gate    chan struct{}
+	batches chan []firewallAction
+}
+
+func (gatedEnforcer) Setup() error { return nil }
+
+func (g gatedEnforcer) Apply(actions []firewallAction) error {
+	<-g.gate
+	g.batches <- actions
+	return nil
+}
+
+func TestFirewallNeverDrops(t *testing.T) {
+	g := gatedEnforcer{gate: make(chan struct{}), batches: make(chan []firewallAction, 16)}
+	f := newFirewall(g)
+
+	// 远超过原来队列长度的操作全部保留, 积压的操作合并成一批
+	const n = 5000
+	for i := 0; i < n; i++ {
+		f.Block("203.0.113.7", time.Minute)
+	}
+	close(g.gate)
+
+	total := 0
+	for total < n {
+		select {
+		case batch := <-g.batches:
+			total += len(batch)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("只执行了 %d/%d 个操作", total, n)
+		}
+	}
+	if total != n {
+		t.Fatalf("执行了 %d 个操作, 期望 %d", total, n)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// 快速解析路径
+//
+// 完整解析(gopacket.NewPacket)为每个数据包分配各层结构体, 而检测只需要地址、
+// 端口和几个标志位。这里用DecodingLayerParser把数据解析到预先分配好的层中,
+// 直接填充packetInfo, 每个数据包不分配内存。
+// 一个fastDecoder只能由一个goroutine使用。
+type fastDecoder struct {
+	parser  *gopacket.DecodingLayerParser
+	decoded []gopacket.LayerType
+
+	eth      layers.Ethernet
+	sll      layers.LinuxSLL
+	loopback layers.Loopback
+	dot1q    layers.Dot1Q
+	ip4      layers.IPv4
+	ip6      layers.IPv6
+	ip6ext   layers.IPv6ExtensionSkipper
+	ip6frag  ipv6FragmentHeader
+	tcp      layers.TCP
+	udp      layers.UDP
+	icmp4    layers.ICMPv4
+	icmp6    layers.ICMPv6
+}
+
+// 按链路层类型创建解析器, 不支持的链路层类型返回错误
+func newFastDecoder(linkType layers.LinkType) (*fastDecoder, error) {
+	var first gopacket.LayerType
+	switch linkType {
+	case layers.LinkTypeEthernet:
+		first = layers.LayerTypeEthernet
+	case layers.LinkTypeLinuxSLL:
+		first = layers.LayerTypeLinuxSLL
+	case layers.LinkTypeNull, layers.LinkTypeLoop:
+		first = layers.LayerTypeLoopback
+	default:
+		return nil, fmt.Errorf("快速解析不支持链路层类型 %s", linkType)
+	}
+
+	d := &fastDecoder{decoded: make([]gopacket.LayerType, 0, 8)}
+	d.parser = gopacket.NewDecodingLayerParser(first)
+	d.parser.SetDecodingLayerContainer(gopacket.DecodingLayerSparse(nil))
+	// 分片头必须在扩展头跳过器之后注册, 覆盖跳过器对分片头的处理
+	for _, layer := range []gopacket.DecodingLayer{
+		&d.eth, &d.sll, &d.loopback, &d.dot1q, &d.ip4, &d.ip6, &d.ip6ext, &d.ip6frag,
+		&d.tcp, &d.udp, &d.icmp4, &d.icmp6,
+	} {
+		d.parser.AddDecodingLayer(layer)
+	}
+	// 应用层和其他不关心的协议到此为止
+	d.parser.IgnoreUnsupported = true
+	return d, nil
+}
+
+// 解析数据包并填充info, 没有网络层的数据包返回false
+//
+// info中不保留对data的引用, data可以是零拷贝读取的缓冲区。
+func (d *fastDecoder) Decode(data []byte, info *packetInfo) bool {
+	*info = packetInfo{length: len(data)}
+	// 截断的数据包返回错误, 但之前解析出的层仍然可用
+	_ = d.parser.DecodeLayers(data, &d.decoded)
+
+	network := false
+	for _, typ := range d.decoded {
+		switch typ {
+		case layers.LayerTypeIPv4:
+			if network {
+				return true // 隧道中的内层数据包不再解析
+			}
+			network = d.setAddrs(d.ip4.SrcIP, d.ip4.DstIP, info)
+			info.protocol = d.ip4.Protocol
+		case layers.LayerTypeIPv6:
+			if network {
+				return true
+			}
+			network = d.setAddrs(d.ip6.SrcIP, d.ip6.DstIP, info)
+			info.protocol = d.ip6.NextHeader
+			if d.ip6.HopByHop != nil {
+				info.protocol = d.ip6.HopByHop.NextHeader
+			}
+		case layers.LayerTypeIPv6Routing, layers.LayerTypeIPv6Destination:
+			info.protocol = d.ip6ext.NextHeader
+		case layers.LayerTypeIPv6Fragment:
+			info.protocol = d.ip6frag.nextHeader
+		case layers.LayerTypeTCP:
+			info.tcp = true
+			info.srcPort = uint16(d.tcp.SrcPort)
+			info.dstPort = uint16(d.tcp.DstPort)
+			info.syn = d.tcp.SYN && !d.tcp.ACK
+		case layers.LayerTypeUDP:
+			info.udp = true
+			info.srcPort = uint16(d.udp.SrcPort)
+			info.dstPort = uint16(d.udp.DstPort)
+			// 与完整解析相同, 只把DNS端口上的数据当作DNS, 只看头部中的QR位
+			payload := d.udp.Payload
+			if d.udp.NextLayerType() == layers.LayerTypeDNS && len(payload) >= 12 && payload[2]&0x80 != 0 {
+				info.dnsResponse = true
+				info.dnsSize = len(payload)
+			}
+		case layers.LayerTypeICMPv4:
+			info.icmpEcho = d.icmp4.TypeCode.Type() == layers.ICMPv4TypeEchoRequest
+		case layers.LayerTypeICMPv6:
+			info.icmpEcho = d.icmp6.TypeCode.Type() == layers.ICMPv6TypeEchoRequest
+		}
+	}
+	return network
+}
+
+func (d *fastDecoder) setAddrs(src, dst []byte, info *packetInfo) bool {
+	srcIP, ok := netip.AddrFromSlice(src)
+	if !ok {
+		return false
+	}
+	info.srcIP = srcIP.Unmap()
+	if dstIP, ok := netip.AddrFromSlice(dst); ok {
+		info.dstIP = dstIP.Unmap()
+	}
+	return true
+}
+
+// IPv6分片头, 非首个分片中没有传输层头部, 不再继续解析
+type ipv6FragmentHeader struct {
+	layers.BaseLayer
+	nextHeader layers.IPProtocol
+	offset     uint16
+}
+
+func (f *ipv6FragmentHeader) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < 8 {
+		df.SetTruncated()
+		return errors.New("IPv6分片头不完整")
+	}
+	f.BaseLayer = layers.BaseLayer{Contents: data[:8], Payload: data[8:]}
+	f.nextHeader = layers.IPProtocol(data[0])
+	f.offset = binary.BigEndian.Uint16(data[2:4]) >> 3
+	return nil
+}
+
+func (f *ipv6FragmentHeader) CanDecode() gopacket.LayerClass {
+	return layers.LayerTypeIPv6Fragment
+}
+
+func (f *ipv6FragmentHeader) NextLayerType() gopacket.LayerType {
+	if f.offset != 0 {
+		return gopacket.LayerTypeFragment
+	}
+	return f.nextHeader.LayerType()
+}
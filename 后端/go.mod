@@ -0,0 +1,22 @@
+module blogguard
+
+go 1.25.0
+
+require (
+	github.com/google/gopacket v1.1.19
+	github.com/prometheus/client_golang v1.19.1
+	go.etcd.io/bbolt v1.5.0
+	golang.org/x/net v0.20.0
+	golang.org/x/sys v0.45.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/kr/text v0.2.0 // indirect
+	github.com/prometheus/client_model v0.5.0 // indirect
+	github.com/prometheus/common v0.48.0 // indirect
+	github.com/prometheus/procfs v0.12.0 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
+)
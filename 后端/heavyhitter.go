@@ -0,0 +1,393 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/maphash"
+	"log/slog"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// 伪造随机源地址的泛洪中每个IP只发几个包, 逐IP统计无法发现。
+// 这里用固定内存的count-min sketch按目的端口、源网段和数据包特征聚合计数,
+// 并维护top-k重流量键; 全局包速率明显超过基线时发出"聚合泛洪"告警。
+
+// count-min sketch: depth行, 每行width个计数器, 估计值取各行的最小值
+//
+// 使用相同哈希种子的sketch可以逐个计数器相加合并。
+type countMinSketch struct {
+	width  int
+	seeds  []maphash.Seed
+	counts [][]uint64
+}
+
+func newSketchSeeds(depth int) []maphash.Seed {
+	seeds := make([]maphash.Seed, depth)
+	for i := range seeds {
+		seeds[i] = maphash.MakeSeed()
+	}
+	return seeds
+}
+
+func newCountMinSketch(width int, seeds []maphash.Seed) *countMinSketch {
+	s := &countMinSketch{
+		width:  width,
+		seeds:  seeds,
+		counts: make([][]uint64, len(seeds)),
+	}
+	for i := range s.counts {
+		s.counts[i] = make([]uint64, width)
+	}
+	return s
+}
+
+// 计数并返回该键的估计值
+func (s *countMinSketch) Add(key heavyHitterKey, n uint64) uint64 {
+	b := key.bytes()
+	var estimate uint64
+	for i, row := range s.counts {
+		j := maphash.Bytes(s.seeds[i], b[:]) % uint64(s.width)
+		row[j] += n
+		if i == 0 || row[j] < estimate {
+			estimate = row[j]
+		}
+	}
+	return estimate
+}
+
+// 返回该键的估计值
+func (s *countMinSketch) Estimate(key heavyHitterKey) uint64 {
+	b := key.bytes()
+	var estimate uint64
+	for i, row := range s.counts {
+		c := row[maphash.Bytes(s.seeds[i], b[:])%uint64(s.width)]
+		if i == 0 || c < estimate {
+			estimate = c
+		}
+	}
+	return estimate
+}
+
+// 把另一个使用相同种子的sketch加到本sketch上
+func (s *countMinSketch) Merge(other *countMinSketch) {
+	for i, row := range s.counts {
+		for j, c := range other.counts[i] {
+			row[j] += c
+		}
+	}
+}
+
+func (s *countMinSketch) Reset() {
+	for _, row := range s.counts {
+		clear(row)
+	}
+}
+
+// 一个重流量键及其在窗口内的估计包数
+type heavyHitter struct {
+	Key   string `json:"key"`
+	Count uint64 `json:"count"`
+}
+
+// 保留估计值最大的k个键
+type topK struct {
+	k     int
+	items map[heavyHitterKey]uint64
+}
+
+func newTopK(k int) *topK {
+	return &topK{k: k, items: make(map[heavyHitterKey]uint64, k)}
+}
+
+func (t *topK) Offer(key heavyHitterKey, estimate uint64) {
+	if _, ok := t.items[key]; ok || len(t.items) < t.k {
+		t.items[key] = estimate
+		return
+	}
+	// k很小, 线性查找最小值即可
+	var minKey heavyHitterKey
+	minCount, found := uint64(0), false
+	for k, c := range t.items {
+		if !found || c < minCount {
+			minKey, minCount, found = k, c, true
+		}
+	}
+	if estimate > minCount {
+		delete(t.items, minKey)
+		t.items[key] = estimate
+	}
+}
+
+// 按估计值从大到小返回, 只在窗口结束时调用, 这时才把键格式化成字符串
+func (t *topK) List() []heavyHitter {
+	list := make([]heavyHitter, 0, len(t.items))
+	for k, c := range t.items {
+		list = append(list, heavyHitter{Key: k.String(), Count: c})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Count > list[j].Count })
+	return list
+}
+
+func (t *topK) Reset() {
+	clear(t.items)
+}
+
+// 一次聚合泛洪告警
+type aggregateAlert struct {
+	At       time.Time     `json:"at"`
+	PPS      float64       `json:"pps"`
+	Baseline float64       `json:"baseline"`
+	Top      []heavyHitter `json:"top"`
+}
+
+// 全局流量监控, 按固定窗口统计
+//
+// 每个处理线程写自己的sketch, 互不加锁; 窗口到期时由一个线程把各分片的sketch
+// 合并, 用合并后的估计值重新排出top-k, 再与基线比较。
+type aggregateMonitor struct {
+	shards      []*aggregateShard
+	merged      *countMinSketch
+	topK        int
+	windowStart atomic.Int64 // 当前窗口的开始时间(UnixNano), 0表示还没有数据包
+
+	closeMu   sync.Mutex // 同一时刻只有一个线程结束窗口, 保护以下字段
+	baseline  float64    // 正常流量的每秒包数(指数加权平均)
+	windows   int        // 已经参与基线计算的窗口数
+	attacking bool
+
+	mu      sync.Mutex
+	lastTop []heavyHitter // 上一个完整窗口的重流量键
+}
+
+// 一个处理线程的计数
+type aggregateShard struct {
+	mu      sync.Mutex
+	sketch  *countMinSketch
+	top     *topK
+	packets uint64
+}
+
+var aggregates *aggregateMonitor
+
+func newAggregateMonitor(cfg heavyHitterConfig, shards int) *aggregateMonitor {
+	seeds := newSketchSeeds(cfg.Depth)
+	m := &aggregateMonitor{
+		shards: make([]*aggregateShard, shards),
+		merged: newCountMinSketch(cfg.Width, seeds),
+		topK:   cfg.TopK,
+	}
+	for i := range m.shards {
+		m.shards[i] = &aggregateShard{
+			sketch: newCountMinSketch(cfg.Width, seeds),
+			top:    newTopK(cfg.TopK),
+		}
+	}
+	return m
+}
+
+// 记录分片 shard 处理的一个数据包
+func (m *aggregateMonitor) Observe(cfg *config, shard int, info *packetInfo, now time.Time) {
+	hh := cfg.HeavyHitters
+	if m == nil || !hh.Enabled {
+		return
+	}
+
+	if m.windowStart.Load() == 0 {
+		m.windowStart.CompareAndSwap(0, now.UnixNano())
+	}
+	m.maybeCloseWindow(hh, now)
+
+	s := m.shards[shard]
+	s.mu.Lock()
+	s.packets++
+	for _, key := range heavyHitterKeys(info) {
+		s.top.Offer(key, s.sketch.Add(key, 1))
+	}
+	s.mu.Unlock()
+}
+
+// 没有数据包时也按时结束窗口, 否则流量完全停止后聚合泛洪永远不会结束
+func (m *aggregateMonitor) Tick(cfg *config, now time.Time) {
+	hh := cfg.HeavyHitters
+	if m == nil || !hh.Enabled {
+		return
+	}
+	m.maybeCloseWindow(hh, now)
+}
+
+// 当前窗口已经到期时结束它, 其他线程正在结束窗口时不等待, 数据包计入下一个窗口
+func (m *aggregateMonitor) maybeCloseWindow(hh heavyHitterConfig, now time.Time) {
+	start := m.windowStart.Load()
+	if start == 0 {
+		return // 还没有数据包
+	}
+	if elapsed := now.Sub(time.Unix(0, start)); elapsed >= hh.Window && m.closeMu.TryLock() {
+		if m.windowStart.Load() == start {
+			m.closeWindow(hh, elapsed, now)
+		}
+		m.closeMu.Unlock()
+	}
+}
+
+// 定期结束到期的聚合窗口, 只用于在线监听, 离线分析按数据包时间结束窗口
+func tickAggregates() {
+	for {
+		cfg := currentConfig()
+		time.Sleep(cfg.HeavyHitters.Window)
+		aggregates.Tick(cfg, time.Now())
+	}
+}
+
+// 结束当前窗口: 合并各分片, 与基线比较, 然后更新基线并清空计数, 调用时需持有closeMu
+func (m *aggregateMonitor) closeWindow(hh heavyHitterConfig, elapsed time.Duration, now time.Time) {
+	var packets uint64
+	candidates := make(map[heavyHitterKey]struct{})
+	for _, s := range m.shards {
+		s.mu.Lock()
+		m.merged.Merge(s.sketch)
+		packets += s.packets
+		for key := range s.top.items {
+			candidates[key] = struct{}{}
+		}
+		s.sketch.Reset()
+		s.top.Reset()
+		s.packets = 0
+		s.mu.Unlock()
+	}
+	m.windowStart.Store(now.UnixNano())
+
+	// 单个分片的top-k只看到部分流量, 用合并后的估计值重新排序
+	top := newTopK(m.topK)
+	for key := range candidates {
+		top.Offer(key, m.merged.Estimate(key))
+	}
+	m.merged.Reset()
+	lastTop := top.List()
+	m.mu.Lock()
+	m.lastTop = lastTop
+	m.mu.Unlock()
+
+	pps := float64(packets) / elapsed.Seconds()
+	warmedUp := m.windows >= hh.WarmupWindows
+	flood := warmedUp && pps >= hh.MinPacketsPerSecond && pps > m.baseline*hh.AlertMultiplier
+	switch {
+	case flood && !m.attacking:
+		m.attacking = true
+		aggregateAlerts.Inc()
+		alert := aggregateAlert{At: now, PPS: pps, Baseline: m.baseline, Top: lastTop}
+		logAggregateAlert(alert)
+		alerts.AggregateFlood(alert)
+	case !flood && m.attacking:
+		m.attacking = false
+		logEvent(slog.LevelInfo, "aggregate_flood_ended", "聚合泛洪已结束",
+			slog.Float64("pps", pps), slog.Float64("baseline", m.baseline))
+		alerts.AggregateFloodEnded()
+	}
+
+	// 攻击期间不更新基线, 避免基线被攻击流量抬高
+	if !m.attacking {
+		if m.windows == 0 {
+			m.baseline = pps
+		} else {
+			m.baseline += hh.BaselineAlpha * (pps - m.baseline)
+		}
+		m.windows++
+	}
+}
+
+// 返回上一个完整窗口的重流量键
+func (m *aggregateMonitor) TopHitters() []heavyHitter {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]heavyHitter(nil), m.lastTop...)
+}
+
+// 聚合键的种类
+const (
+	keyDstPort   uint8 = iota // 目的端口
+	keySrcPrefix              // 源网段
+	keySignature              // 数据包特征
+)
+
+// 一个聚合键, 固定大小, 每个数据包计数时不分配内存
+type heavyHitterKey struct {
+	kind   uint8
+	proto  layers.IPProtocol
+	syn    bool
+	is6    bool
+	bits   uint8  // 源网段的前缀长度
+	port   uint16 // 目的端口
+	length uint32 // 长度区间的下限
+	addr   [16]byte
+}
+
+// 哈希用的字节表示
+func (k heavyHitterKey) bytes() [26]byte {
+	var b [26]byte
+	b[0] = k.kind
+	b[1] = byte(k.proto)
+	if k.syn {
+		b[2] = 1
+	}
+	if k.is6 {
+		b[3] = 1
+	}
+	b[4] = k.bits
+	binary.LittleEndian.PutUint16(b[5:], k.port)
+	binary.LittleEndian.PutUint32(b[7:], k.length)
+	copy(b[11:], k.addr[:])
+	return b
+}
+
+// 告警和管理接口中显示的形式
+func (k heavyHitterKey) String() string {
+	proto := strings.ToLower(k.proto.String())
+	switch k.kind {
+	case keyDstPort:
+		return fmt.Sprintf("dport %s/%d", proto, k.port)
+	case keySrcPrefix:
+		addr := netip.AddrFrom16(k.addr)
+		if !k.is6 {
+			addr = addr.Unmap()
+		}
+		return "src " + netip.PrefixFrom(addr, int(k.bits)).String()
+	}
+	flags := ""
+	if k.syn {
+		flags = " syn"
+	}
+	return fmt.Sprintf("sig %s/%d len~%d%s", proto, k.port, k.length, flags)
+}
+
+// 每个数据包计入的聚合键: 目的端口、源网段和数据包特征
+func heavyHitterKeys(info *packetInfo) [3]heavyHitterKey {
+	src := heavyHitterKey{kind: keySrcPrefix, bits: 24}
+	if info.srcIP.Is6() {
+		src.is6, src.bits = true, 48
+	}
+	if prefix, err := info.srcIP.Prefix(int(src.bits)); err == nil {
+		src.addr = prefix.Addr().As16()
+	}
+
+	// 特征: 协议、目的端口、长度区间和TCP标志, 随机源地址的泛洪往往特征一致
+	return [3]heavyHitterKey{
+		{kind: keyDstPort, proto: info.protocol, port: info.dstPort},
+		src,
+		{kind: keySignature, proto: info.protocol, port: info.dstPort, length: uint32(info.length / 64 * 64), syn: info.syn},
+	}
+}
+
+func logAggregateAlert(alert aggregateAlert) {
+	logEvent(slog.LevelWarn, "aggregate_flood", "检测到聚合泛洪, 单个IP可能都未超限",
+		slog.Float64("pps", alert.PPS), slog.Float64("baseline", alert.Baseline), slog.Any("top", alert.Top))
+}
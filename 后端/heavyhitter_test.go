@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+func TestHeavyHitterKeyString(t *testing.T) {
+	for _, tc := range []struct {
+		info packetInfo
+		want [3]string
+	}{
+		{
+			info: packetInfo{srcIP: netip.MustParseAddr("203.0.113.77"), protocol: layers.IPProtocolTCP, dstPort: 443, length: 130, syn: true},
+			want: [3]string{"dport tcp/443", "src 203.0.113.0/24", "sig tcp/443 len~128 syn"},
+		},
+		{
+			info: packetInfo{srcIP: netip.MustParseAddr("2001:db8:1:2::9"), protocol: layers.IPProtocolUDP, dstPort: 53, length: 60},
+			want: [3]string{"dport udp/53", "src 2001:db8:1::/48", "sig udp/53 len~0"},
+		},
+	} {
+		keys := heavyHitterKeys(&tc.info)
+		for i, key := range keys {
+			if got := key.String(); got != tc.want[i] {
+				t.Errorf("键 %d: 得到 %q, 期望 %q", i, got, tc.want[i])
+			}
+		}
+	}
+}
+
+func TestAggregateObserveAllocs(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.HeavyHitters.Enabled = true
+	m := newAggregateMonitor(cfg.HeavyHitters, 1)
+	info := packetInfo{srcIP: netip.MustParseAddr("203.0.113.77"), protocol: layers.IPProtocolTCP, dstPort: 443, length: 130, syn: true}
+	s := m.shards[0]
+
+	// 只测量计数路径, 不包括窗口结束时的合并和格式化
+	allocs := testing.AllocsPerRun(1000, func() {
+		info.srcIP = info.srcIP.Next()
+		for _, key := range heavyHitterKeys(&info) {
+			s.top.Offer(key, s.sketch.Add(key, 1))
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("每个数据包分配 %.1f 次内存, 期望0", allocs)
+	}
+}
+
+func TestAggregateTopMergesShards(t *testing.T) {
+	cfg := defaultConfig()
+	hh := cfg.HeavyHitters
+	hh.Enabled = true
+	m := newAggregateMonitor(hh, 2)
+
+	for i := 0; i < 100; i++ {
+		info := packetInfo{srcIP: netip.MustParseAddr("198.51.100.7"), protocol: layers.IPProtocolUDP, dstPort: 123, length: 468}
+		for _, key := range heavyHitterKeys(&info) {
+			s := m.shards[i%2]
+			s.top.Offer(key, s.sketch.Add(key, 1))
+		}
+	}
+	m.closeMu.Lock()
+	m.closeWindow(hh, hh.Window, time.Now())
+	m.closeMu.Unlock()
+
+	top := m.TopHitters()
+	if len(top) != 3 {
+		t.Fatalf("得到 %d 个重流量键, 期望3: %v", len(top), top)
+	}
+	for _, h := range top {
+		if h.Count != 100 {
+			t.Errorf("%s: 估计值 %d, 期望100", h.Key, h.Count)
+		}
+	}
+}
+
+func TestAggregateTickEndsFloodWithoutPackets(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.HeavyHitters.Enabled = true
+	hh := cfg.HeavyHitters
+	m := newAggregateMonitor(hh, 1)
+	m.windows, m.baseline = hh.WarmupWindows, 10
+
+	start := time.Unix(1700000000, 0)
+	m.windowStart.Store(start.UnixNano())
+	m.shards[0].packets = uint64(hh.MinPacketsPerSecond*hh.Window.Seconds()) * 10
+
+	m.Tick(cfg, start.Add(hh.Window/2))
+	if m.attacking {
+		t.Fatal("窗口没有到期时不应该结束窗口")
+	}
+	m.Tick(cfg, start.Add(hh.Window))
+	if !m.attacking {
+		t.Fatal("应该检测到聚合泛洪")
+	}
+	// 攻击流量完全停止, 没有数据包触发窗口结束
+	m.Tick(cfg, start.Add(2*hh.Window))
+	if m.attacking {
+		t.Fatal("流量停止后聚合泛洪应该结束")
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/google/gopacket/pcap"
+)
+
+// 按名称查找要监听的接口, 没有指定时自动选择一个
+func resolveInterfaces(names []string) ([]pcap.Interface, error) {
+	devices, err := pcap.FindAllDevs()
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		device, err := selectBestInterface(devices)
+		if err != nil {
+			return nil, err
+		}
+		return []pcap.Interface{device}, nil
+	}
+
+	byName := make(map[string]pcap.Interface, len(devices))
+	for _, dev := range devices {
+		byName[dev.Name] = dev
+	}
+	var selected []pcap.Interface
+	for _, name := range names {
+		dev, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("找不到网络接口 %q, 使用 -list-interfaces 查看可用的接口", name)
+		}
+		selected = append(selected, dev)
+	}
+	return selected, nil
+}
+
+// 列出所有可以监听的接口, 标出自动选择时会使用的接口
+func listInterfaces() error {
+	devices, err := pcap.FindAllDevs()
+	if err != nil {
+		return err
+	}
+	best, _ := selectBestInterface(devices)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "\t名称\t地址\t说明")
+	for _, dev := range devices {
+		mark := ""
+		if dev.Name == best.Name {
+			mark = "*"
+		}
+		var addrs []string
+		for _, addr := range dev.Addresses {
+			if ip, ok := netip.AddrFromSlice(addr.IP); ok {
+				addrs = append(addrs, ip.Unmap().String())
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", mark, dev.Name, strings.Join(addrs, ", "), dev.Description)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Println("* 未指定 -interface 时自动选择的接口")
+	return nil
+}
+
+// 自动选择最佳网络接口
+//
+// 优先选择同时有全局IPv4和IPv6地址的接口, 其次是只有其中一种的接口,
+// 本地回环和只有链路本地地址的接口排在最后。
+func selectBestInterface(devices []pcap.Interface) (pcap.Interface, error) {
+	best, bestScore := -1, -1
+	for i, dev := range devices {
+		if score := interfaceScore(dev); score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+
+	if best < 0 {
+		return pcap.Interface{}, fmt.Errorf("没有找到可用的网络接口")
+	}
+	return devices[best], nil
+}
+
+// 接口的优先级: 有全局IPv4地址加2分, 有全局IPv6地址加1分
+func interfaceScore(dev pcap.Interface) int {
+	hasV4, hasV6 := false, false
+	for _, addr := range dev.Addresses {
+		ip, ok := netip.AddrFromSlice(addr.IP)
+		if !ok {
+			continue
+		}
+		ip = ip.Unmap()
+		if ip.IsLoopback() || !ip.IsGlobalUnicast() {
+			continue // 跳过回环和链路本地地址
+		}
+		if ip.Is4() {
+			hasV4 = true
+		} else {
+			hasV6 = true
+		}
+	}
+
+	score := 0
+	if hasV4 {
+		score += 2
+	}
+	if hasV6 {
+		score++
+	}
+	return score
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/netip"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// 跳过IPv6扩展头, 返回最终的上层协议
+//
+// 逐跳选项头由gopacket并入IPv6层本身, 路由、分片和目的选项头是独立的层。
+// 非首个分片中没有传输层头部, 返回的协议只能说明分片承载的是什么。
+func ipv6UpperProtocol(packet gopacket.Packet, ip6 *layers.IPv6) layers.IPProtocol {
+	proto := ip6.NextHeader
+	if ip6.HopByHop != nil {
+		proto = ip6.HopByHop.NextHeader
+	}
+	for _, layer := range packet.Layers() {
+		switch ext := layer.(type) {
+		case *layers.IPv6Routing:
+			proto = ext.NextHeader
+		case *layers.IPv6Fragment:
+			proto = ext.NextHeader
+		case *layers.IPv6Destination:
+			proto = ext.NextHeader
+		}
+	}
+	return proto
+}
+
+// 按顺序列出数据包中的IPv6扩展头, 用于打印
+func ipv6ExtensionChain(packet gopacket.Packet, ip6 *layers.IPv6) []string {
+	var chain []string
+	if ip6.HopByHop != nil {
+		chain = append(chain, "逐跳选项")
+	}
+	for _, layer := range packet.Layers() {
+		switch ext := layer.(type) {
+		case *layers.IPv6Routing:
+			chain = append(chain, "路由")
+		case *layers.IPv6Fragment:
+			if ext.FragmentOffset == 0 {
+				chain = append(chain, "分片(首个)")
+			} else {
+				chain = append(chain, "分片")
+			}
+		case *layers.IPv6Destination:
+			chain = append(chain, "目的选项")
+		}
+	}
+	return chain
+}
+
+// 统计和阻塞时使用的来源键
+//
+// IPv4按单个地址统计; IPv6主机通常拥有整个/64, 攻击者可以随意更换
+// 其中的地址, 所以IPv6按配置的前缀长度统计, 阻塞时也阻塞整个前缀。
+func sourceKey(cfg *config, addr netip.Addr) string {
+	if addr.Is6() && cfg.IPv6SourcePrefix < 128 {
+		if prefix, err := addr.Prefix(cfg.IPv6SourcePrefix); err == nil {
+			return prefix.String()
+		}
+	}
+	return addr.String()
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 日志中累计多少条记录后重写为只包含当前阻塞的精简版本
+const journalCompactThreshold = 10000
+
+// 阻塞日志中的一条记录
+type journalRecord struct {
+	Op     string      `json:"op"` // block 或 unblock
+	Time   time.Time   `json:"time"`
+	Entry  *blockEntry `json:"entry,omitempty"`
+	Target string      `json:"target,omitempty"`
+}
+
+// 追加写入的阻塞日志, 重启后据此恢复阻塞列表
+//
+// 所有方法都在持有mu时调用, 为nil时什么也不做(例如离线分析模式)。
+type blockJournal struct {
+	path    string
+	file    *os.File
+	records int // 自上次精简以来写入的记录数
+}
+
+// 打开阻塞日志并重放其中的记录, 返回仍未过期的阻塞
+func openBlockJournal(path string, now time.Time) (*blockJournal, []*blockEntry, error) {
+	active, err := replayJournal(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entries := make([]*blockEntry, 0, len(active))
+	for _, entry := range active {
+		if entry.ActiveAt(now) {
+			entries = append(entries, entry)
+		}
+	}
+
+	j := &blockJournal{path: path}
+	if err := j.compact(entries); err != nil {
+		return nil, nil, err
+	}
+	return j, entries, nil
+}
+
+// 按顺序重放日志, 得到每个目标最后的状态
+func replayJournal(path string) (map[string]*blockEntry, error) {
+	active := make(map[string]*blockEntry)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return active, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var rec journalRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// 进程崩溃时最后一行可能只写了一半
+			logEvent(slog.LevelWarn, "journal_error", "跳过无法解析的阻塞日志行",
+				slog.String("path", path), slog.Int("line", line), slog.String("error", err.Error()))
+			continue
+		}
+		switch rec.Op {
+		case "block":
+			if rec.Entry != nil {
+				active[rec.Entry.Target] = rec.Entry
+			}
+		case "unblock":
+			delete(active, rec.Target)
+		}
+	}
+	return active, scanner.Err()
+}
+
+func (j *blockJournal) RecordBlock(entry *blockEntry) {
+	j.write(journalRecord{Op: "block", Time: entry.BlockedAt, Entry: entry})
+}
+
+func (j *blockJournal) RecordUnblock(target string, now time.Time) {
+	j.write(journalRecord{Op: "unblock", Time: now, Target: target})
+}
+
+func (j *blockJournal) write(rec journalRecord) {
+	if j == nil {
+		return
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		logEvent(slog.LevelError, "journal_error", "写入阻塞日志失败", slog.String("error", err.Error()))
+		return
+	}
+	if _, err := j.file.Write(append(data, '\n')); err != nil {
+		logEvent(slog.LevelError, "journal_error", "写入阻塞日志失败", slog.String("error", err.Error()))
+		return
+	}
+	j.records++
+}
+
+// 记录过多时用当前阻塞列表重写日志
+func (j *blockJournal) MaybeCompact(blocked map[string]*blockEntry) {
+	if j == nil || j.records < journalCompactThreshold {
+		return
+	}
+	entries := make([]*blockEntry, 0, len(blocked))
+	for _, entry := range blocked {
+		entries = append(entries, entry)
+	}
+	if err := j.compact(entries); err != nil {
+		logEvent(slog.LevelError, "journal_error", "精简阻塞日志失败", slog.String("error", err.Error()))
+	}
+}
+
+// 用给定的阻塞列表重写日志并重新打开用于追加
+func (j *blockJournal) compact(entries []*blockEntry) error {
+	if err := writeJournalFile(j.path, entries); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if j.file != nil {
+		j.file.Close()
+	}
+	j.file = file
+	j.records = 0
+	return nil
+}
+
+// 先写临时文件再原子替换, 避免中途崩溃丢失整个日志
+func writeJournalFile(path string, entries []*blockEntry) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	w := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(w)
+	for _, entry := range entries {
+		if err = encoder.Encode(journalRecord{Op: "block", Time: entry.BlockedAt, Entry: entry}); err != nil {
+			return err
+		}
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (j *blockJournal) Close() error {
+	if j == nil || j.file == nil {
+		return nil
+	}
+	return j.file.Close()
+}
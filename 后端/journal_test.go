@@ -0,0 +1,133 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func journalTargets(entries []*blockEntry) []string {
+	targets := make([]string, len(entries))
+	for i, e := range entries {
+		targets[i] = e.Target
+	}
+	slices.Sort(targets)
+	return targets
+}
+
+func TestBlockJournalReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocks.journal")
+	t0 := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	j, entries, err := openBlockJournal(path, t0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("新日志不应该有阻塞: %v", journalTargets(entries))
+	}
+
+	// 阻塞 -> 解除 -> 再次阻塞, 以最后一条记录为准
+	j.RecordBlock(&blockEntry{Target: "203.0.113.7", Reason: attackFlood, Offense: 1, BlockedAt: t0, Expires: t0.Add(time.Minute)})
+	j.RecordUnblock("203.0.113.7", t0.Add(10*time.Second))
+	j.RecordBlock(&blockEntry{Target: "203.0.113.7", Reason: attackSYNFlood, Offense: 2, BlockedAt: t0.Add(20 * time.Second), Expires: t0.Add(time.Hour)})
+	// 已经过期的阻塞和已解除的阻塞不恢复, 永久阻塞一直有效
+	j.RecordBlock(&blockEntry{Target: "198.51.100.0/24", Reason: attackFlood, Offense: 1, BlockedAt: t0, Expires: t0.Add(time.Minute)})
+	j.RecordBlock(&blockEntry{Target: "192.0.2.1", Reason: attackUDPFlood, Offense: 1, BlockedAt: t0})
+	j.RecordBlock(&blockEntry{Target: "2001:db8::/64", Reason: attackManual, Offense: 1, BlockedAt: t0, Expires: t0.Add(time.Hour)})
+	j.RecordUnblock("2001:db8::/64", t0.Add(time.Minute))
+	j.Close()
+
+	// 进程崩溃时最后一行只写了一半
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"op":"block","time":"2026-03-01T12:05:00Z","entry":{"target":"10.0.0.1"`)
+	f.Close()
+
+	j, entries, err = openBlockJournal(path, t0.Add(5*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if got, want := journalTargets(entries), []string{"192.0.2.1", "203.0.113.7"}; !slices.Equal(got, want) {
+		t.Fatalf("恢复的阻塞: %v, 期望 %v", got, want)
+	}
+	for _, e := range entries {
+		switch e.Target {
+		case "203.0.113.7":
+			if e.Reason != attackSYNFlood || e.Offense != 2 || !e.Expires.Equal(t0.Add(time.Hour)) {
+				t.Errorf("应该恢复最后一次阻塞: %+v", e)
+			}
+		case "192.0.2.1":
+			if !e.Permanent() {
+				t.Errorf("永久阻塞被修改: %+v", e)
+			}
+		}
+	}
+
+	// 打开时已经重写为只包含当前阻塞
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 2 {
+		t.Errorf("重写后的日志有 %d 行, 期望2:\n%s", lines, data)
+	}
+}
+
+func TestBlockJournalCompact(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "blocks.journal")
+	t0 := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	j, _, err := openBlockJournal(path, t0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	kept := &blockEntry{Target: "203.0.113.7", Reason: attackFlood, Offense: 3, BlockedAt: t0, Expires: t0.Add(time.Hour)}
+	j.RecordBlock(kept)
+	j.RecordBlock(&blockEntry{Target: "198.51.100.1", Reason: attackFlood, Offense: 1, BlockedAt: t0, Expires: t0.Add(time.Minute)})
+	j.RecordUnblock("198.51.100.1", t0.Add(time.Minute))
+	blocked := map[string]*blockEntry{kept.Target: kept}
+
+	// 记录数没有达到阈值时不重写
+	j.MaybeCompact(blocked)
+	if j.records != 3 {
+		t.Fatalf("不应该重写日志, records = %d", j.records)
+	}
+
+	j.records = journalCompactThreshold
+	j.MaybeCompact(blocked)
+	if j.records != 0 {
+		t.Fatalf("应该重写日志, records = %d", j.records)
+	}
+	active, err := replayJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 1 || active[kept.Target] == nil || active[kept.Target].Offense != 3 {
+		t.Fatalf("重写后的日志: %v", active)
+	}
+
+	// 重写后继续追加到新文件
+	j.RecordUnblock(kept.Target, t0.Add(2*time.Minute))
+	if active, _ := replayJournal(path); len(active) != 0 {
+		t.Fatalf("重写后的追加没有写入新文件: %v", active)
+	}
+
+	// 临时文件已经原子替换, 不会留在目录中
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != "blocks.journal" {
+		t.Errorf("目录中的文件: %v", files)
+	}
+}
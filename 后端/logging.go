@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// 结构化日志
+//
+// 每条日志的event字段是固定的事件名, 日志系统按它筛选和解析; msg是给人看的说明。
+// 逐包记录使用比debug更低的trace级别, 默认不输出。
+
+// 比debug更详细的级别, 用于逐包记录
+const levelTrace = slog.Level(-8)
+
+var (
+	logLevel slog.LevelVar // 可以在SIGHUP时修改
+	logger   = slog.New(newLogHandler(os.Stdout, "json"))
+)
+
+func newLogHandler(w io.Writer, format string) slog.Handler {
+	opts := &slog.HandlerOptions{
+		Level: &logLevel,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.LevelKey && a.Value.Any() == levelTrace {
+				a.Value = slog.StringValue("TRACE")
+			}
+			return a
+		},
+	}
+	if format == "text" {
+		return slog.NewTextHandler(w, opts)
+	}
+	return slog.NewJSONHandler(w, opts)
+}
+
+// 按配置设置日志格式和级别
+func setupLogging(c logConfig) {
+	logLevel.Set(parseLogLevel(c.Level))
+	logger = slog.New(newLogHandler(os.Stdout, c.Format))
+}
+
+func parseLogLevel(level string) slog.Level {
+	switch level {
+	case "trace":
+		return levelTrace
+	case "debug":
+		return slog.LevelDebug
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
+
+// 记录一个事件
+func logEvent(level slog.Level, event, msg string, attrs ...slog.Attr) {
+	logger.LogAttrs(context.Background(), level, msg, append([]slog.Attr{slog.String("event", event)}, attrs...)...)
+}
+
+// 记录启动失败并退出
+func fatal(msg string, err error) {
+	logEvent(slog.LevelError, "startup_failed", msg, slog.String("error", err.Error()))
+	os.Exit(1)
+}
+
+// 是否开启了逐包记录, 关闭时快速解析路径不需要保留完整的数据包
+func packetTraceEnabled() bool {
+	return logLevel.Level() <= levelTrace
+}
+
+// 在trace级别记录数据包的基本信息
+func tracePacket(info *packetInfo, packet gopacket.Packet) {
+	attrs := []slog.Attr{
+		slog.String("interface", info.iface),
+		slog.String("src_ip", info.srcIP.String()),
+		slog.String("dst_ip", info.dstIP.String()),
+		slog.String("protocol", info.protocol.String()),
+		slog.Int("length", info.length),
+	}
+	if info.tcp || info.udp {
+		attrs = append(attrs, slog.Int("src_port", int(info.srcPort)), slog.Int("dst_port", int(info.dstPort)))
+	}
+	if eth, ok := packet.Layer(layers.LayerTypeEthernet).(*layers.Ethernet); ok {
+		attrs = append(attrs, slog.String("src_mac", eth.SrcMAC.String()), slog.String("dst_mac", eth.DstMAC.String()))
+	}
+	if ip6, ok := packet.NetworkLayer().(*layers.IPv6); ok {
+		attrs = append(attrs,
+			slog.Int("hop_limit", int(ip6.HopLimit)),
+			slog.String("flow_label", fmt.Sprintf("%#x", ip6.FlowLabel)))
+		if chain := ipv6ExtensionChain(packet, ip6); len(chain) > 0 {
+			attrs = append(attrs, slog.String("ipv6_extensions", strings.Join(chain, " -> ")))
+		}
+	}
+	logEvent(levelTrace, "packet", "数据包", attrs...)
+}
+
+// 阻塞事件的公共字段
+func blockAttrs(entry *blockEntry) []slog.Attr {
+	unit := "packets_per_second"
+	if entry.Reason == attackDNSAmplification {
+		unit = "bytes_per_second"
+	}
+	attrs := []slog.Attr{
+		slog.String("ip", entry.Target),
+		slog.String("reason", string(entry.Reason)),
+		slog.Float64("rate", entry.Rate),
+		slog.String("rate_unit", unit),
+		slog.Int("offense", entry.Offense),
+		slog.String("interface", entry.Interface),
+		slog.Bool("permanent", entry.Permanent()),
+	}
+	if !entry.Permanent() {
+		attrs = append(attrs,
+			slog.Float64("duration_seconds", entry.Expires.Sub(entry.BlockedAt).Seconds()),
+			slog.Time("expires", entry.Expires))
+	}
+	return attrs
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus指标, 供Grafana绘图和告警
+
+var (
+	packetsProcessed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "blogguard_packets_processed_total",
+		Help: "处理线程处理的数据包数, 按接口和协议区分",
+	}, []string{"interface", "protocol"})
+
+	blocksIssued = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "blogguard_blocks_total",
+		Help: "发出的阻塞数, 按攻击类型区分",
+	}, []string{"reason"})
+
+	aggregateAlerts = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "blogguard_aggregate_alerts_total",
+		Help: "聚合泛洪告警次数",
+	})
+
+	packetLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "blogguard_packet_processing_seconds",
+		Help:    "单个数据包在处理线程中的解析和检测耗时",
+		Buckets: prometheus.ExponentialBuckets(250e-9, 2, 14), // 250ns ~ 2ms
+	})
+)
+
+// 在线抓包句柄, 抓包统计在采集指标时读取
+var (
+	capturesMu   sync.Mutex
+	openHandles  []liveCapture
+	captureDescs = struct {
+		received, dropped, ifDropped *prometheus.Desc
+	}{
+		received:  prometheus.NewDesc("blogguard_capture_received_packets_total", "抓包句柄收到的数据包数", []string{"interface"}, nil),
+		dropped:   prometheus.NewDesc("blogguard_capture_dropped_packets_total", "缓冲区满时内核丢弃的数据包数", []string{"interface"}, nil),
+		ifDropped: prometheus.NewDesc("blogguard_capture_interface_dropped_packets_total", "网卡或驱动丢弃的数据包数(仅pcap后端)", []string{"interface"}, nil),
+	}
+)
+
+// 一个抓包句柄的累计统计
+type captureStats struct {
+	received  uint64
+	dropped   uint64
+	ifDropped uint64
+}
+
+func registerCaptures(captures []liveCapture) {
+	capturesMu.Lock()
+	openHandles = append(openHandles, captures...)
+	capturesMu.Unlock()
+}
+
+// 关闭句柄之前调用, 已关闭的句柄不能再读取统计
+func unregisterCaptures() {
+	capturesMu.Lock()
+	openHandles = nil
+	capturesMu.Unlock()
+}
+
+// 按接口汇总抓包统计, fanout时一个接口有多个句柄
+type captureCollector struct{}
+
+func (captureCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- captureDescs.received
+	ch <- captureDescs.dropped
+	ch <- captureDescs.ifDropped
+}
+
+func (captureCollector) Collect(ch chan<- prometheus.Metric) {
+	for iface, t := range captureTotals() {
+		ch <- prometheus.MustNewConstMetric(captureDescs.received, prometheus.CounterValue, float64(t.received), iface)
+		ch <- prometheus.MustNewConstMetric(captureDescs.dropped, prometheus.CounterValue, float64(t.dropped), iface)
+		ch <- prometheus.MustNewConstMetric(captureDescs.ifDropped, prometheus.CounterValue, float64(t.ifDropped), iface)
+	}
+}
+
+// 按接口汇总当前打开的句柄的抓包统计
+func captureTotals() map[string]captureStats {
+	capturesMu.Lock()
+	defer capturesMu.Unlock()
+	totals := make(map[string]captureStats)
+	for _, c := range openHandles {
+		stats, err := c.stats()
+		if err != nil {
+			continue
+		}
+		t := totals[c.iface]
+		t.received += stats.received
+		t.dropped += stats.dropped
+		t.ifDropped += stats.ifDropped
+		totals[c.iface] = t
+	}
+	return totals
+}
+
+// 当前状态在采集时读取, 不在数据包处理路径上维护
+func newMetricsRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		packetsProcessed, blocksIssued, aggregateAlerts, packetLatency,
+		captureCollector{},
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "blogguard_tracked_sources",
+			Help: "当前跟踪的来源数",
+		}, func() float64 {
+			sources, _ := engine.trackedCounts()
+			return float64(sources)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "blogguard_tracked_prefixes",
+			Help: "当前跟踪的聚合网段数",
+		}, func() float64 {
+			_, prefixes := engine.trackedCounts()
+			return float64(prefixes)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "blogguard_active_blocks",
+			Help: "当前生效的阻塞数",
+		}, func() float64 {
+			mu.RLock()
+			defer mu.RUnlock()
+			return float64(len(blockedIPs))
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "blogguard_tracker_evictions_total",
+			Help: "因容量或空闲被淘汰的统计条目数",
+		}, func() float64 {
+			return float64(trackerEvictions.Load())
+		}),
+	)
+	return reg
+}
+
+func startMetricsServer(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(newMetricsRegistry(), promhttp.HandlerOpts{}))
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      10 * time.Second,
+	}
+
+	go func() {
+		logEvent(slog.LevelInfo, "metrics_started", "指标服务已启动", slog.String("url", "http://"+addr+"/metrics"))
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logEvent(slog.LevelError, "metrics_error", "指标服务异常退出", slog.String("error", err.Error()))
+		}
+	}()
+}
+
+// 按接口和协议区分的数据包计数器, 每个分片缓存自己用到的计数器, 避免每个包都查标签
+type packetLabel struct {
+	iface string
+	proto string
+}
+
+func (s *shard) countPacket(info *packetInfo) {
+	label := packetLabel{iface: info.iface, proto: protocolLabel(info)}
+	counter, ok := s.packetCounters[label]
+	if !ok {
+		counter = packetsProcessed.WithLabelValues(label.iface, label.proto)
+		s.packetCounters[label] = counter
+	}
+	counter.Inc()
+}
+
+func protocolLabel(info *packetInfo) string {
+	switch {
+	case info.tcp:
+		return "tcp"
+	case info.udp:
+		return "udp"
+	case info.protocol == layers.IPProtocolICMPv4:
+		return "icmp"
+	case info.protocol == layers.IPProtocolICMPv6:
+		return "icmpv6"
+	}
+	return "other"
+}
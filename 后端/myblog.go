@@ -1,161 +1,317 @@
 package main
 
 import (
-	"fmt"
-	"log"
-	"net"
+	"errors"
+	"flag"
+	"log/slog"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/google/gopacket"
-	"github.com/google/gopacket/layers"
-	"github.com/google/gopacket/pcap"
 )
 
-// 用于跟踪每个源IP的数据包计数和时间
-type ipStats struct {
-	packetCount int
-	firstSeen   time.Time
-	lastSeen    time.Time
+// 一条阻塞记录
+type blockEntry struct {
+	Target    string     `json:"target"`              // 被阻塞的IP或网段
+	Reason    attackType `json:"reason"`              // 触发阻塞的攻击类型
+	Rate      float64    `json:"rate"`                // 触发时观察到的速率
+	Offense   int        `json:"offense"`             // 第几次违规
+	Interface string     `json:"interface,omitempty"` // 检测到攻击的接口
+	BlockedAt time.Time  `json:"blocked_at"`
+	Expires   time.Time  `json:"expires"` // 为零值时表示永久阻塞
 }
 
-// 配置参数
-const (
-	maxPacketsPerSecond = 100 // 每秒最大数据包数
-	blockDuration       = 60  // 阻塞时间(秒)
-)
+// 阻塞是否永久有效
+func (e *blockEntry) Permanent() bool {
+	return e.Expires.IsZero()
+}
+
+// 在 now 时刻是否仍然有效
+func (e *blockEntry) ActiveAt(now time.Time) bool {
+	return e.Permanent() || now.Before(e.Expires)
+}
+
+// 距离到期的剩余时间, 永久阻塞返回0
+func (e *blockEntry) Remaining(now time.Time) time.Duration {
+	if e.Permanent() {
+		return 0
+	}
+	return e.Expires.Sub(now)
+}
 
 var (
-	ipCounters = make(map[string]*ipStats)
-	mu         sync.Mutex
-	blockedIPs = make(map[string]time.Time)
+	// 保护阻塞状态: blockedIPs、blockedPrefixLens、offenses、journal和blockHistory
+	// 需要同时持有分片锁时, 总是先锁分片再锁mu
+	mu         sync.RWMutex
+	blockedIPs = make(map[string]*blockEntry)
+	fw         *firewall
+	journal    *blockJournal
+
+	blockHistory []*blockEntry // 离线分析时记录所有阻塞事件, 用于生成报告
+	recordBlocks = false
 )
 
 func main() {
-	// 自动选择合适的网络接口
-	device, err := selectBestInterface()
+	cfg, opts, err := loadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
-		log.Fatalf("无法选择合适的网络接口: %v", err)
+		fatal("无法加载配置", err)
+	}
+	setupLogging(cfg.Log)
+	if opts.listInterfaces {
+		if err := listInterfaces(); err != nil {
+			fatal("无法列出网络接口", err)
+		}
+		return
 	}
+	activeConfig.Store(cfg)
+	allowed, _ := parseAllowlist(cfg.Allowlist) // 已在loadConfig中校验
+	trusted.SetConfigured(allowed)
+
+	engine = newPipeline(cfg.Pipeline.Workers, cfg.Pipeline.QueueSize)
+	engine.applyTrackingLimits(cfg)
+	aggregates = newAggregateMonitor(cfg.HeavyHitters, len(engine.shards))
 
-	fmt.Printf("已选择网络接口: %s (%s)\n", device.Name, device.Description)
+	if opts.readFile != "" {
+		if err := runOffline(opts.readFile); err != nil {
+			fatal("离线分析失败", err)
+		}
+		return
+	}
 
-	// 打开网络接口进行监听
-	handle, err := pcap.OpenLive(device.Name, 1600, true, pcap.BlockForever)
+	// 打开评论数据库, 重启后评论不会丢失
+	store, err := openCommentStore(cfg.Comments.Database)
 	if err != nil {
-		log.Fatalf("无法打开网络接口: %v", err)
+		fatal("无法打开评论数据库", err)
 	}
-	defer handle.Close()
+	defer store.Close()
 
-	fmt.Printf("开始监听接口 %s...\n", device.Name)
+	// 启动评论服务, 与数据包监听并行运行
+	startCommentServer(cfg.Comments.Listen, store)
+	if cfg.Metrics.Listen != "" {
+		startMetricsServer(cfg.Metrics.Listen)
+	}
 
-	// 设置数据包捕获循环
-	packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
-	for packet := range packetSource.Packets() {
-		processPacket(packet)
+	// 初始化防火墙, 被阻塞的IP在内核中直接丢弃
+	var runner commandRunner = execRunner{}
+	if cfg.Enforcement.DryRun {
+		runner = dryRunRunner{}
+	}
+	backend, err := newEnforcer(cfg.Enforcement.Backend, runner)
+	if err != nil {
+		fatal("无法初始化防火墙", err)
+	}
+	if err := backend.Setup(); err != nil {
+		fatal("无法初始化防火墙", err)
+	}
+	fw = newFirewall(backend)
+	alerts = newAlerter(cfg.Alerts)
+
+	// 从阻塞日志恢复重启前仍未到期的阻塞
+	if cfg.BlockJournal != "" {
+		restoreBlocks(cfg.BlockJournal)
+		defer journal.Close()
+	}
+	go expireBlocks()
+	go sweepTrackers()
+	go tickAggregates()
+	go watchReload(os.Args[1:])
+	if err := startAdminServer(cfg.Admin, os.Args[1:], opts.configPath); err != nil {
+		fatal("无法启动管理接口", err)
 	}
-}
 
-// 自动选择最佳网络接口
-func selectBestInterface() (pcap.Interface, error) {
-	devices, err := pcap.FindAllDevs()
+	// 使用配置中指定的网络接口, 未指定时自动选择一个
+	devices, err := resolveInterfaces(cfg.Interfaces)
 	if err != nil {
-		return pcap.Interface{}, err
+		fatal("无法选择网络接口", err)
 	}
 
-	// 优先选择有IPv4地址且非本地回环的接口
-	for _, dev := range devices {
-		for _, addr := range dev.Addresses {
-			if ipnet, ok := addr.IPNet.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
-				if ipnet.IP.To4() != nil { // 检查是否为IPv4地址
-					return dev, nil
-				}
-			}
+	// 每个抓包句柄一个goroutine, 共用同一组处理线程和阻塞列表
+	var captures sync.WaitGroup
+	for _, device := range devices {
+		handles, err := openCaptures(cfg, device.Name)
+		if err != nil {
+			logEvent(slog.LevelError, "capture_error", "无法打开网络接口",
+				slog.String("interface", device.Name), slog.String("error", err.Error()))
+			os.Exit(1)
 		}
-	}
+		registerCaptures(handles)
+		logEvent(slog.LevelInfo, "capture_started", "开始监听接口",
+			slog.String("interface", device.Name),
+			slog.String("description", device.Description),
+			slog.String("backend", cfg.CaptureBackend),
+			slog.Int("sockets", len(handles)),
+			slog.String("decoder", cfg.Decoder),
+			slog.String("filter", cfg.BPFFilter),
+			slog.Bool("inbound_only", cfg.InboundOnly),
+			slog.Int("workers", len(engine.shards)))
 
-	// 如果没有找到IPv4接口，尝试返回第一个可用接口
-	if len(devices) > 0 {
-		return devices[0], nil
+		for _, c := range handles {
+			defer c.close()
+			captures.Add(1)
+			go func(c liveCapture) {
+				defer captures.Done()
+				// 抓包循环只负责读取和分发, 检测在各个worker中进行
+				capturePackets(c.source, c.linkType, c.iface, cfg.Decoder, func(gopacket.CaptureInfo) time.Time {
+					return time.Now()
+				})
+				logEvent(slog.LevelWarn, "capture_stopped", "抓包已结束", slog.String("interface", c.iface))
+			}(c)
+		}
 	}
+	defer unregisterCaptures() // 先于关闭句柄执行
 
-	return pcap.Interface{}, fmt.Errorf("没有找到可用的网络接口")
+	captures.Wait()
+	engine.Close()
 }
 
-// 处理捕获到的数据包
-func processPacket(packet gopacket.Packet) {
-	// 提取网络层
-	networkLayer := packet.NetworkLayer()
-	if networkLayer == nil {
-		return // 没有网络层信息
-	}
+// 处理分配给本分片的数据包, now 为数据包的到达时间, packet 为nil时不记录逐包日志
+func (s *shard) processPacket(info *packetInfo, packet gopacket.Packet, now time.Time) {
+	cfg := currentConfig()
+	source := sourceKey(cfg, info.srcIP)
 
-	srcIP := networkLayer.NetworkFlow().Src().String()
-	
-	// 检查IP是否被阻塞
-	mu.Lock()
-	if unblockTime, blocked := blockedIPs[srcIP]; blocked {
-		if time.Now().Before(unblockTime) {
-			mu.Unlock()
+	// 全局聚合统计包括被阻塞来源的数据包
+	aggregates.Observe(cfg, s.id, info, now)
+
+	// 检查IP或其所在网段是否被阻塞, 阻塞记录创建后不再修改, 读锁下即可判断
+	mu.RLock()
+	target, entry, blocked := findBlockLocked(info.srcIP, source)
+	mu.RUnlock()
+	if blocked {
+		if entry.ActiveAt(now) {
 			return // 忽略被阻塞IP的数据包
 		}
-		// 解除阻塞
-		delete(blockedIPs, srcIP)
-		fmt.Printf("已解除对 %s 的阻塞\n", srcIP)
+		mu.Lock()
+		// 其他worker可能已经解除或重新阻塞了该目标
+		if blockedIPs[target] == entry {
+			unblockLocked(target, "expired", now)
+		}
+		mu.Unlock()
 	}
-	mu.Unlock()
 
-	// 更新IP统计信息
-	mu.Lock()
-	stats, exists := ipCounters[srcIP]
+	// 更新IP统计信息并运行各协议检测器
+	s.mu.Lock()
+	stats, exists := s.ipCounters.Get(source, now)
 	if !exists {
-		stats = &ipStats{
-			packetCount: 1,
-			firstSeen:   time.Now(),
-			lastSeen:    time.Now(),
-		}
-		ipCounters[srcIP] = stats
+		stats = newIPStats(cfg)
+		s.ipCounters.Put(source, stats, now)
+	}
+	// 可信来源照常计数, 但永远不会被阻塞
+	if reason, rate, hit := stats.observe(cfg, info, now); hit && !trusted.Overlaps(source) {
+		mu.Lock()
+		blockLocked(cfg, source, reason, rate, info.iface, now)
+		mu.Unlock()
+		// 重置计数器
+		s.ipCounters.Delete(source)
+	}
+	// 分散在同一网段多个地址上的流量按网段聚合检测
+	s.observePrefixesLocked(cfg, info, now)
+	s.mu.Unlock()
+
+	// 逐包日志 (trace级别)
+	if packet != nil && packetTraceEnabled() {
+		tracePacket(info, packet)
+	}
+}
+
+// 阻塞一个来源, 重复违规的来源阻塞时间逐级延长, 调用时需持有mu
+func blockLocked(cfg *config, target string, reason attackType, rate float64, iface string, now time.Time) *blockEntry {
+	offense := recordOffenseLocked(cfg, target, now)
+	duration := escalatedDuration(cfg, offense)
+
+	entry := &blockEntry{
+		Target:    target,
+		Reason:    reason,
+		Rate:      rate,
+		Offense:   offense,
+		Interface: iface,
+		BlockedAt: now,
+	}
+	if duration > 0 {
+		entry.Expires = now.Add(duration)
+	}
+	addBlockLocked(entry, duration)
+	return entry
+}
+
+// 记录阻塞并下发防火墙规则, duration 为0时永久阻塞, 调用时需持有mu
+func addBlockLocked(entry *blockEntry, duration time.Duration) {
+	setBlockLocked(entry)
+	blocksIssued.WithLabelValues(string(entry.Reason)).Inc()
+	journal.RecordBlock(entry)
+	if recordBlocks {
+		blockHistory = append(blockHistory, entry)
+	}
+	fw.Block(entry.Target, duration)
+
+	msg := "检测到可能的Flood攻击, 已阻塞"
+	if entry.Reason == attackManual {
+		msg = "已手动阻塞"
 	} else {
-		stats.packetCount++
-		stats.lastSeen = time.Now()
-	}
-	
-	// 检查是否超过速率限制
-	duration := stats.lastSeen.Sub(stats.firstSeen).Seconds()
-	if duration >= 1 { // 至少观察1秒
-		packetsPerSecond := float64(stats.packetCount) / duration
-		if packetsPerSecond > maxPacketsPerSecond {
-			blockedIPs[srcIP] = time.Now().Add(time.Second * blockDuration)
-			fmt.Printf("检测到可能的Flood攻击! 已阻塞 %s (%.2f 包/秒)\n", 
-				srcIP, packetsPerSecond)
-			// 重置计数器
-			delete(ipCounters, srcIP)
-		} else if duration > 5 { // 每5秒重置一次计数器，避免长期累积
-			stats.packetCount = 0
-			stats.firstSeen = time.Now()
-		}
+		alerts.Block(entry)
+	}
+	logEvent(slog.LevelWarn, "ip_blocked", msg, blockAttrs(entry)...)
+}
+
+// 解除阻塞并删除防火墙规则, cause 为解除的原因, 调用时需持有mu
+func unblockLocked(ip, cause string, now time.Time) {
+	attrs := []slog.Attr{slog.String("ip", ip), slog.String("cause", cause)}
+	if entry, ok := blockedIPs[ip]; ok {
+		attrs = append(attrs,
+			slog.String("reason", string(entry.Reason)),
+			slog.String("interface", entry.Interface),
+			slog.Float64("blocked_seconds", now.Sub(entry.BlockedAt).Seconds()))
 	}
-	mu.Unlock()
 
-	// 打印数据包信息 (可选)
-	printPacketInfo(packet)
+	deleteBlockLocked(ip)
+	journal.RecordUnblock(ip, now)
+	fw.Unblock(ip)
+	logEvent(slog.LevelInfo, "ip_unblocked", "已解除阻塞", attrs...)
 }
 
-// 打印数据包基本信息
-func printPacketInfo(packet gopacket.Packet) {
-	// 获取以太网层
-	ethLayer := packet.Layer(layers.LayerTypeEthernet)
-	if ethLayer != nil {
-		eth, _ := ethLayer.(*layers.Ethernet)
-		fmt.Printf("源MAC: %s  目的MAC: %s  ", eth.SrcMAC, eth.DstMAC)
+// 读取阻塞日志, 重新阻塞尚未到期的IP
+func restoreBlocks(path string) {
+	now := time.Now()
+	j, entries, err := openBlockJournal(path, now)
+	if err != nil {
+		fatal("无法打开阻塞日志", err)
 	}
 
-	// 获取网络层
-	netLayer := packet.Layer(layers.LayerTypeIPv4)
-	if netLayer != nil {
-		ip, _ := netLayer.(*layers.IPv4)
-		fmt.Printf("源IP: %s  目的IP: %s  协议: %s\n", 
-			ip.SrcIP, ip.DstIP, ip.Protocol)
+	mu.Lock()
+	journal = j
+	for _, entry := range entries {
+		if trusted.Overlaps(entry.Target) {
+			journal.RecordUnblock(entry.Target, now)
+			continue
+		}
+		setBlockLocked(entry)
+		offenses[entry.Target] = &offenseRecord{count: entry.Offense, lastOffense: entry.BlockedAt}
+		fw.Block(entry.Target, entry.Remaining(now))
+	}
+	mu.Unlock()
+
+	if len(entries) > 0 {
+		logEvent(slog.LevelInfo, "blocks_restored", "已从阻塞日志恢复阻塞",
+			slog.String("path", path), slog.Int("count", len(entries)))
+	}
+}
+
+// 定期清理到期的阻塞, 被内核丢弃的IP不会再有数据包触发解除
+func expireBlocks() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		mu.Lock()
+		for ip, entry := range blockedIPs {
+			if !entry.ActiveAt(now) {
+				unblockLocked(ip, "expired", now)
+			}
+		}
+		pruneOffensesLocked(currentConfig(), now)
+		journal.MaybeCompact(blockedIPs)
+		mu.Unlock()
 	}
 }
-    
\ No newline at end of file
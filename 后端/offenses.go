@@ -0,0 +1,74 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// 每个来源的违规历史, 用于计算逐级延长的阻塞时间
+type offenseRecord struct {
+	count       int
+	lastOffense time.Time
+}
+
+// 所有来源的违规历史, 受mu保护
+var offenses = make(map[string]*offenseRecord)
+
+// 记录一次违规并返回当前违规次数
+//
+// 超过 lookback 没有再犯的来源重新从第一次算起;
+// 在此之前, 每经过一个 decay_interval 没有违规, 次数减一。
+func recordOffenseLocked(cfg *config, target string, now time.Time) int {
+	rec, ok := offenses[target]
+	if !ok {
+		rec = &offenseRecord{}
+		offenses[target] = rec
+	}
+	rec.count = decayedOffenses(cfg, rec, now) + 1
+	rec.lastOffense = now
+	return rec.count
+}
+
+// 按经过的时间衰减后的违规次数
+func decayedOffenses(cfg *config, rec *offenseRecord, now time.Time) int {
+	esc := cfg.Escalation
+	idle := now.Sub(rec.lastOffense)
+	if rec.count == 0 || idle >= esc.Lookback {
+		return 0
+	}
+	count := rec.count
+	if esc.DecayInterval > 0 && idle > 0 {
+		count -= int(idle / esc.DecayInterval)
+	}
+	if count < 0 {
+		count = 0
+	}
+	return count
+}
+
+// 第 offense 次违规的阻塞时间, 返回0表示永久阻塞
+//
+// 时长为 block_duration * factor^(offense-1), 不超过 max_duration。
+func escalatedDuration(cfg *config, offense int) time.Duration {
+	esc := cfg.Escalation
+	if esc.PermanentAfter > 0 && offense >= esc.PermanentAfter {
+		return 0
+	}
+	d := float64(cfg.BlockDuration) * math.Pow(esc.Factor, float64(offense-1))
+	if d > float64(esc.MaxDuration) {
+		return esc.MaxDuration
+	}
+	return time.Duration(d)
+}
+
+// 删除已经完全衰减的违规历史, 调用时需持有mu
+func pruneOffensesLocked(cfg *config, now time.Time) {
+	for target, rec := range offenses {
+		if _, blocked := blockedIPs[target]; blocked {
+			continue
+		}
+		if decayedOffenses(cfg, rec, now) == 0 {
+			delete(offenses, target)
+		}
+	}
+}
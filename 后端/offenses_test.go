@@ -0,0 +1,117 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEscalatedDuration(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		factor   float64
+		max      time.Duration
+		permAt   int
+		offenses []int
+		want     []time.Duration
+	}{
+		{"逐次翻倍", 2, 24 * time.Hour, 0,
+			[]int{1, 2, 3, 4, 5},
+			[]time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 16 * time.Minute}},
+		{"系数为1时不延长", 1, 24 * time.Hour, 0,
+			[]int{1, 2, 10},
+			[]time.Duration{time.Minute, time.Minute, time.Minute}},
+		{"非整数系数", 1.5, 24 * time.Hour, 0,
+			[]int{1, 2, 3},
+			[]time.Duration{time.Minute, 90 * time.Second, 135 * time.Second}},
+		{"不超过max_duration", 2, 10 * time.Minute, 0,
+			[]int{4, 5, 100},
+			[]time.Duration{8 * time.Minute, 10 * time.Minute, 10 * time.Minute}},
+		{"permanent_after", 2, 24 * time.Hour, 3,
+			[]int{2, 3, 4},
+			[]time.Duration{2 * time.Minute, 0, 0}},
+	} {
+		cfg := defaultConfig()
+		cfg.BlockDuration = time.Minute
+		cfg.Escalation.Factor = tc.factor
+		cfg.Escalation.MaxDuration = tc.max
+		cfg.Escalation.PermanentAfter = tc.permAt
+		for i, offense := range tc.offenses {
+			if got := escalatedDuration(cfg, offense); got != tc.want[i] {
+				t.Errorf("%s: 第 %d 次违规阻塞 %s, 期望 %s", tc.name, offense, got, tc.want[i])
+			}
+		}
+	}
+}
+
+func TestDecayedOffenses(t *testing.T) {
+	t0 := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	cfg := defaultConfig()
+	cfg.Escalation.Lookback = 24 * time.Hour
+	cfg.Escalation.DecayInterval = time.Hour
+
+	for _, tc := range []struct {
+		name  string
+		count int
+		idle  time.Duration
+		want  int
+	}{
+		{"没有违规", 0, 0, 0},
+		{"刚刚违规", 4, 0, 4},
+		{"不到一个衰减间隔", 4, 59 * time.Minute, 4},
+		{"一个衰减间隔", 4, time.Hour, 3},
+		{"三个半衰减间隔", 4, 3*time.Hour + 30*time.Minute, 1},
+		{"衰减到0为止", 4, 10 * time.Hour, 0},
+		{"超过lookback", 30, 24 * time.Hour, 0},
+		{"时间回退", 4, -time.Hour, 4},
+	} {
+		rec := &offenseRecord{count: tc.count, lastOffense: t0}
+		if got := decayedOffenses(cfg, rec, t0.Add(tc.idle)); got != tc.want {
+			t.Errorf("%s: %d 次违规经过 %s 后为 %d, 期望 %d", tc.name, tc.count, tc.idle, got, tc.want)
+		}
+	}
+
+	// decay_interval 为0时只在超过 lookback 后清零
+	cfg.Escalation.DecayInterval = 0
+	rec := &offenseRecord{count: 4, lastOffense: t0}
+	if got := decayedOffenses(cfg, rec, t0.Add(23*time.Hour)); got != 4 {
+		t.Errorf("不衰减时得到 %d, 期望4", got)
+	}
+}
+
+func TestRecordOffenseDecays(t *testing.T) {
+	t0 := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	cfg := defaultConfig()
+	cfg.Escalation.Lookback = 24 * time.Hour
+	cfg.Escalation.DecayInterval = time.Hour
+
+	mu.Lock()
+	defer mu.Unlock()
+	offenses = make(map[string]*offenseRecord)
+	defer func() { offenses = make(map[string]*offenseRecord) }()
+
+	const target = "203.0.113.7"
+	for i, step := range []struct {
+		at   time.Duration
+		want int
+	}{
+		{0, 1},
+		{time.Minute, 2},
+		{2 * time.Minute, 3},
+		{2*time.Hour + 2*time.Minute, 2}, // 安静两小时: 3-2, 再加上这一次
+		{30 * time.Hour, 1},              // 超过 lookback, 重新开始
+	} {
+		if got := recordOffenseLocked(cfg, target, t0.Add(step.at)); got != step.want {
+			t.Errorf("第 %d 次记录: 违规次数 %d, 期望 %d", i+1, got, step.want)
+		}
+	}
+
+	// 已经完全衰减的记录被清理
+	pruneOffensesLocked(cfg, t0.Add(30*time.Hour+30*time.Minute))
+	if _, ok := offenses[target]; !ok {
+		t.Fatal("还没有衰减完的记录被删除")
+	}
+	pruneOffensesLocked(cfg, t0.Add(31*time.Hour))
+	if _, ok := offenses[target]; ok {
+		t.Fatal("完全衰减的记录应该被删除")
+	}
+}
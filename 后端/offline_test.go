@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOfflineFixture(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.BPFFilter = "" // 录制文件中只有发往博客的流量
+	activeConfig.Store(cfg)
+	t.Cleanup(func() { activeConfig.Store(defaultConfig()) })
+
+	engine = newPipeline(2, cfg.Pipeline.QueueSize)
+	engine.applyTrackingLimits(cfg)
+	aggregates = newAggregateMonitor(cfg.HeavyHitters, len(engine.shards))
+	mu.Lock()
+	blockHistory, blockedIPs, offenses = nil, make(map[string]*blockEntry), make(map[string]*offenseRecord)
+	mu.Unlock()
+	t.Cleanup(func() { recordBlocks = false })
+
+	if err := runOffline("testdata/offline.pcap"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 录制内容: 203.0.113.7 从0秒开始每5ms一个SYN; 2001:db8:1:2::/64 内的地址从1秒开始
+	// 每5ms一个UDP包; 198.51.100.20 每200ms一个正常的TCP包, 不应该被阻塞
+	t0 := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	want := []struct {
+		target string
+		reason attackType
+		at     time.Duration
+	}{
+		{"203.0.113.7", attackSYNFlood, 250 * time.Millisecond},        // 第51个SYN
+		{"2001:db8:1:2::/64", attackUDPFlood, 1400 * time.Millisecond}, // 第81个UDP包, 按/64阻塞
+	}
+	if len(blockHistory) != len(want) {
+		t.Fatalf("得到 %d 个阻塞事件, 期望 %d", len(blockHistory), len(want))
+	}
+	for i, w := range want {
+		e := blockHistory[i]
+		if e.Target != w.target || e.Reason != w.reason {
+			t.Errorf("阻塞事件 %d: 得到 %s %s, 期望 %s %s", i, e.Target, e.Reason, w.target, w.reason)
+		}
+		if at := t0.Add(w.at); !e.BlockedAt.Equal(at) {
+			t.Errorf("%s: 阻塞时间 %s, 期望 %s", e.Target, e.BlockedAt.Format(time.RFC3339Nano), at.Format(time.RFC3339Nano))
+		}
+		if !e.Expires.Equal(e.BlockedAt.Add(cfg.BlockDuration)) || e.Offense != 1 {
+			t.Errorf("%s: 第%d次违规, 阻塞至 %s", e.Target, e.Offense, e.Expires.Format(time.RFC3339Nano))
+		}
+	}
+}
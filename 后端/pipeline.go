@@ -0,0 +1,153 @@
+package main
+
+import (
+	"hash/maphash"
+	"net/netip"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// 数据包处理流水线
+//
+// 抓包goroutine只解析到网络层, 按来源所在网段的哈希把数据包分发给固定数量的worker。
+// 每个worker独占一个分片的统计表: 同一来源以及同一聚合网段的数据包总是由同一个
+// worker按顺序处理, 分片之间不共享锁。阻塞列表是全局的, 读多写少, 用读写锁mu保护。
+
+// 一个分片: 一个worker和它的统计表
+type shard struct {
+	id int
+
+	// 保护本分片的统计表, 除worker外清理器和管理接口也会访问
+	mu             sync.Mutex
+	ipCounters     *lruTable[string, *ipStats]
+	prefixCounters *lruTable[netip.Prefix, *slidingWindow]
+
+	queue          chan queuedPacket
+	packetCounters map[packetLabel]prometheus.Counter // 只由worker访问
+}
+
+type queuedPacket struct {
+	info    packetInfo
+	decoded bool            // info已由快速解析路径填充
+	packet  gopacket.Packet // 完整解析路径的数据包, 快速解析路径只在需要打印时才有
+	now     time.Time
+}
+
+type pipeline struct {
+	seed   maphash.Seed
+	shards []*shard
+	wg     sync.WaitGroup
+}
+
+// 全局处理流水线
+var engine *pipeline
+
+// 创建流水线并启动worker, workers<=0时使用CPU核数
+func newPipeline(workers, queueSize int) *pipeline {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	p := &pipeline{
+		seed:   maphash.MakeSeed(),
+		shards: make([]*shard, workers),
+	}
+	for i := range p.shards {
+		s := &shard{
+			id:             i,
+			ipCounters:     newLRUTable[string, *ipStats](0),
+			prefixCounters: newLRUTable[netip.Prefix, *slidingWindow](0),
+			queue:          make(chan queuedPacket, queueSize),
+			packetCounters: make(map[packetLabel]prometheus.Counter),
+		}
+		p.shards[i] = s
+		p.wg.Add(1)
+		go s.run(&p.wg)
+	}
+	return p
+}
+
+// 把数据包交给负责其来源的worker, worker处理不过来时阻塞,
+// 由内核的抓包缓冲区承受积压(丢包计入pcap统计)
+func (p *pipeline) Dispatch(packet gopacket.Packet, iface string, now time.Time) {
+	s := p.shards[0]
+	if networkLayer := packet.NetworkLayer(); networkLayer != nil {
+		if addr, ok := netip.AddrFromSlice(networkLayer.NetworkFlow().Src().Raw()); ok {
+			s = p.shardFor(currentConfig(), addr.Unmap())
+		}
+	}
+	s.queue <- queuedPacket{info: packetInfo{iface: iface}, packet: packet, now: now}
+}
+
+// 分发已经由快速解析路径解析好的数据包, packet 只在需要打印时传入
+func (p *pipeline) DispatchInfo(info *packetInfo, packet gopacket.Packet, now time.Time) {
+	s := p.shardFor(currentConfig(), info.srcIP)
+	s.queue <- queuedPacket{info: *info, decoded: true, packet: packet, now: now}
+}
+
+// 停止接收数据包并等待所有worker处理完队列
+func (p *pipeline) Close() {
+	for _, s := range p.shards {
+		close(s.queue)
+	}
+	p.wg.Wait()
+}
+
+// 按来源所在的最大聚合网段选择分片,
+// 这样网段聚合统计和其中每个来源的统计都落在同一个分片内
+func (p *pipeline) shardFor(cfg *config, addr netip.Addr) *shard {
+	if len(p.shards) == 1 {
+		return p.shards[0]
+	}
+	key := shardPrefix(cfg, addr).Addr().As16()
+	return p.shards[maphash.Bytes(p.seed, key[:])%uint64(len(p.shards))]
+}
+
+// 来源所属的最短前缀: 配置的聚合网段中最短的一个, 没有时为来源键本身
+func shardPrefix(cfg *config, addr netip.Addr) netip.Prefix {
+	bits := addr.BitLen()
+	if addr.Is6() && cfg.IPv6SourcePrefix < bits {
+		bits = cfg.IPv6SourcePrefix
+	}
+	for _, agg := range cfg.Aggregates {
+		if agg.matches(addr) && agg.PrefixLen < bits {
+			bits = agg.PrefixLen
+		}
+	}
+	prefix, _ := addr.Prefix(bits)
+	return prefix
+}
+
+func (s *shard) run(wg *sync.WaitGroup) {
+	defer wg.Done()
+	for item := range s.queue {
+		start := time.Now()
+		if !item.decoded && !decodePacket(item.packet, &item.info) {
+			continue
+		}
+		s.countPacket(&item.info)
+		s.processPacket(&item.info, item.packet, item.now)
+		packetLatency.Observe(time.Since(start).Seconds())
+	}
+}
+
+// 所有分片跟踪的来源数和网段数
+func (p *pipeline) trackedCounts() (sources, prefixes int) {
+	p.eachShard(func(s *shard) {
+		sources += s.ipCounters.Len()
+		prefixes += s.prefixCounters.Len()
+	})
+	return sources, prefixes
+}
+
+// 对每个分片执行fn, 执行时持有该分片的锁
+func (p *pipeline) eachShard(fn func(s *shard)) {
+	for _, s := range p.shards {
+		s.mu.Lock()
+		fn(s)
+		s.mu.Unlock()
+	}
+}
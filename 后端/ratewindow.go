@@ -0,0 +1,90 @@
+package main
+
+import "time"
+
+// 滑动窗口计数器
+//
+// 把窗口切成若干个等宽的桶, 时间推进时滚动清零过期的桶。
+// 额外保留一个刚滑出窗口的桶, 按它与窗口的重叠比例计入,
+// 这样任意时刻统计的都是最近 window 时长内的事件数, 不受重置时机影响。
+type slidingWindow struct {
+	window      time.Duration
+	bucketWidth time.Duration
+	buckets     []uint64 // 环形缓冲区, 共 n+1 个桶
+	head        int      // 当前桶的下标
+	headStart   time.Time
+	total       uint64 // 所有桶的计数之和
+}
+
+func newSlidingWindow(window time.Duration, buckets int) *slidingWindow {
+	if buckets < 1 {
+		buckets = 1
+	}
+	return &slidingWindow{
+		window:      window,
+		bucketWidth: window / time.Duration(buckets),
+		buckets:     make([]uint64, buckets+1),
+	}
+}
+
+// 记录 n 个事件
+func (w *slidingWindow) Add(now time.Time, n uint64) {
+	w.advance(now)
+	w.buckets[w.head] += n
+	w.total += n
+}
+
+// 返回最近一个窗口内的事件数(估计值)
+func (w *slidingWindow) Count(now time.Time) float64 {
+	w.advance(now)
+	if w.headStart.IsZero() {
+		return 0
+	}
+
+	// 当前桶已经过去的比例, 决定最旧的桶还有多少落在窗口内
+	elapsed := now.Sub(w.headStart)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	overlap := 1 - float64(elapsed)/float64(w.bucketWidth)
+
+	oldest := w.buckets[(w.head+1)%len(w.buckets)]
+	return float64(w.total-oldest) + float64(oldest)*overlap
+}
+
+// 返回最近一个窗口内的平均每秒事件数
+func (w *slidingWindow) Rate(now time.Time) float64 {
+	return w.Count(now) / w.window.Seconds()
+}
+
+// 窗口内是否已经没有任何事件
+func (w *slidingWindow) Empty(now time.Time) bool {
+	w.advance(now)
+	return w.total == 0
+}
+
+// 把窗口推进到 now, 清零滑出窗口的桶
+func (w *slidingWindow) advance(now time.Time) {
+	if w.headStart.IsZero() {
+		w.headStart = now.Truncate(w.bucketWidth)
+		return
+	}
+	if now.Before(w.headStart.Add(w.bucketWidth)) {
+		return // 仍在当前桶内(或时间回退)
+	}
+
+	steps := int(now.Sub(w.headStart) / w.bucketWidth)
+	if steps >= len(w.buckets) {
+		for i := range w.buckets {
+			w.buckets[i] = 0
+		}
+		w.total = 0
+	} else {
+		for i := 0; i < steps; i++ {
+			w.head = (w.head + 1) % len(w.buckets)
+			w.total -= w.buckets[w.head]
+			w.buckets[w.head] = 0
+		}
+	}
+	w.headStart = w.headStart.Add(time.Duration(steps) * w.bucketWidth)
+}
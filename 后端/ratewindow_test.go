@@ -0,0 +1,120 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlidingWindow(t *testing.T) {
+	// 1秒窗口, 10个100ms的桶; t0与桶的边界对齐
+	t0 := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	ms := time.Millisecond
+
+	// add>0 时在 at 记录事件, 否则检查 at 时的计数
+	type step struct {
+		at    time.Duration
+		add   uint64
+		count float64
+	}
+	for _, tc := range []struct {
+		name  string
+		steps []step
+	}{
+		{"空窗口", []step{
+			{at: 0, count: 0},
+		}},
+		{"同一个桶内", []step{
+			{at: 0, add: 5},
+			{at: 50 * ms, add: 3},
+			{at: 99 * ms, count: 8},
+		}},
+		{"每个桶一个事件", []step{
+			{at: 0, add: 1}, {at: 100 * ms, add: 1}, {at: 200 * ms, add: 1}, {at: 300 * ms, add: 1},
+			{at: 400 * ms, add: 1}, {at: 500 * ms, add: 1}, {at: 600 * ms, add: 1}, {at: 700 * ms, add: 1},
+			{at: 800 * ms, add: 1}, {at: 900 * ms, add: 1},
+			{at: 950 * ms, count: 10},
+			{at: 1000 * ms, count: 10}, // 桶边界: 最旧的桶完整计入
+			{at: 1050 * ms, count: 9.5},
+			{at: 1100 * ms, count: 9},
+		}},
+		{"最旧的桶按重叠比例计入", []step{
+			{at: 0, add: 10},
+			{at: 1000 * ms, count: 10},
+			{at: 1025 * ms, count: 7.5},
+			{at: 1050 * ms, count: 5}, // 一半落在窗口内
+			{at: 1099 * ms, count: 0.1},
+			{at: 1100 * ms, count: 0},
+		}},
+		{"重置后的突发不会少算", []step{
+			{at: 900 * ms, add: 60},
+			{at: 1000 * ms, add: 60},
+			{at: 1000 * ms, count: 120}, // 固定窗口在1秒时清零, 只能看到60
+			{at: 1500 * ms, count: 120},
+			{at: 1950 * ms, count: 90},
+			{at: 2000 * ms, count: 60},
+		}},
+		{"环形缓冲区多次回绕", []step{
+			{at: 0, add: 1}, {at: 300 * ms, add: 1}, {at: 600 * ms, add: 1}, {at: 900 * ms, add: 1},
+			{at: 1200 * ms, add: 1}, {at: 1500 * ms, add: 1}, {at: 1800 * ms, add: 1}, {at: 2100 * ms, add: 1},
+			{at: 2400 * ms, add: 1}, {at: 2700 * ms, add: 1}, {at: 3000 * ms, add: 1},
+			{at: 3000 * ms, count: 4}, // 2000~3000ms 之间的 2100, 2400, 2700, 3000
+			{at: 3100 * ms, count: 4},
+			{at: 3150 * ms, count: 3.5},
+		}},
+		{"空闲超过窗口后重新开始", []step{
+			{at: 0, add: 3},
+			{at: 5 * time.Second, add: 2},
+			{at: 5 * time.Second, count: 2},
+			{at: 5*time.Second + 999*ms, count: 2},
+		}},
+		{"时间回退时不推进窗口", []step{
+			{at: 500 * ms, add: 4},
+			{at: 450 * ms, add: 1},
+			{at: 400 * ms, count: 5},
+			{at: 500 * ms, count: 5},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := newSlidingWindow(time.Second, 10)
+			for _, s := range tc.steps {
+				now := t0.Add(s.at)
+				if s.add > 0 {
+					w.Add(now, s.add)
+					continue
+				}
+				if got := w.Count(now); !floatEqual(got, s.count) {
+					t.Errorf("%s: Count = %v, 期望 %v", s.at, got, s.count)
+				}
+			}
+		})
+	}
+}
+
+func TestSlidingWindowRateAndEmpty(t *testing.T) {
+	t0 := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	w := newSlidingWindow(2*time.Second, 4)
+
+	if !w.Empty(t0) {
+		t.Fatal("新窗口应该为空")
+	}
+	w.Add(t0, 30)
+	w.Add(t0.Add(time.Second), 10)
+	if got := w.Rate(t0.Add(time.Second)); !floatEqual(got, 20) {
+		t.Errorf("Rate = %v, 期望 20", got)
+	}
+	if w.Empty(t0.Add(2 * time.Second)) {
+		t.Error("窗口内还有事件")
+	}
+	// 最后一个事件所在的桶完全滑出窗口
+	if !w.Empty(t0.Add(3*time.Second + 500*time.Millisecond)) {
+		t.Error("窗口过去后应该为空")
+	}
+	if got := w.Count(t0.Add(4 * time.Second)); got != 0 {
+		t.Errorf("Count = %v, 期望 0", got)
+	}
+}
+
+func floatEqual(a, b float64) bool {
+	d := a - b
+	return d < 1e-9 && d > -1e-9
+}
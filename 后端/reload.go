@@ -0,0 +1,131 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// 当前生效的配置, 收到SIGHUP后整体替换, 数据包处理时无需加锁读取
+var (
+	activeConfig atomic.Pointer[config]
+	reloadMu     sync.Mutex
+)
+
+func init() {
+	activeConfig.Store(defaultConfig())
+}
+
+func currentConfig() *config {
+	return activeConfig.Load()
+}
+
+// 收到SIGHUP时重新读取配置文件
+func watchReload(args []string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		if _, err := reloadConfig(args); err != nil {
+			logEvent(slog.LevelError, "config_reload_failed", "重新加载配置失败, 继续使用旧配置", slog.String("error", err.Error()))
+		}
+	}
+}
+
+// 按启动时的参数重新加载配置并应用到运行中的检测流程
+//
+// 只替换配置本身, 各分片的统计表和blockedIPs保持不变, 已阻塞的IP不会被放行,
+// 除非它被加入了可信来源列表。
+// 已经创建的滑动窗口保持原来的长度, 新的窗口参数对之后出现的IP生效。
+// 返回需要重启才能生效的配置项。
+func reloadConfig(args []string) ([]string, error) {
+	reloadMu.Lock() // SIGHUP和管理接口可能同时触发
+	defer reloadMu.Unlock()
+
+	c, _, err := loadConfig(args)
+	if err != nil {
+		return nil, err
+	}
+
+	old := currentConfig()
+	pending := restartRequired(old, c)
+	// 过滤条件直接替换到打开的句柄上, 失败时才需要重启
+	if old.BPFFilter != c.BPFFilter || old.InboundOnly != c.InboundOnly {
+		if err := setCaptureFilters(c); err != nil {
+			logEvent(slog.LevelError, "capture_error", "无法应用新的抓包过滤条件", slog.String("error", err.Error()))
+			pending = append(pending, "bpf_filter/inbound_only")
+		}
+	}
+	for _, name := range pending {
+		logEvent(slog.LevelWarn, "restart_required", "配置项需要重启才能生效", slog.String("option", name))
+	}
+
+	activeConfig.Store(c)
+	logLevel.Set(parseLogLevel(c.Log.Level))  // 日志格式需要重启才能修改
+	allowed, _ := parseAllowlist(c.Allowlist) // 已在loadConfig中校验
+	trusted.SetConfigured(allowed)
+
+	engine.applyTrackingLimits(c)
+
+	// 新加入可信列表的来源如果正在被阻塞, 立即解除
+	mu.Lock()
+	releaseTrustedLocked(time.Now())
+	mu.Unlock()
+
+	logEvent(slog.LevelInfo, "config_reloaded", "配置已重新加载")
+	return pending, nil
+}
+
+// 返回无法在运行中修改的配置项
+func restartRequired(old, c *config) []string {
+	var names []string
+	if !slices.Equal(old.Interfaces, c.Interfaces) {
+		names = append(names, "interfaces")
+	}
+	if old.SnapLen != c.SnapLen {
+		names = append(names, "snaplen")
+	}
+	if old.Promiscuous != c.Promiscuous {
+		names = append(names, "promiscuous")
+	}
+	if old.CaptureBackend != c.CaptureBackend || old.AFPacket != c.AFPacket {
+		names = append(names, "capture_backend/afpacket")
+	}
+	if old.Decoder != c.Decoder {
+		names = append(names, "decoder")
+	}
+	if old.Pipeline != c.Pipeline {
+		names = append(names, "pipeline")
+	}
+	if old.BlockJournal != c.BlockJournal {
+		names = append(names, "block_journal")
+	}
+	if old.HeavyHitters.Width != c.HeavyHitters.Width || old.HeavyHitters.Depth != c.HeavyHitters.Depth ||
+		old.HeavyHitters.TopK != c.HeavyHitters.TopK {
+		names = append(names, "heavy_hitters.width/depth/top_k")
+	}
+	if old.Enforcement != c.Enforcement {
+		names = append(names, "enforcement")
+	}
+	if old.Comments != c.Comments {
+		names = append(names, "comments")
+	}
+	if old.Metrics != c.Metrics {
+		names = append(names, "metrics")
+	}
+	if old.Admin != c.Admin {
+		names = append(names, "admin")
+	}
+	if !reflect.DeepEqual(old.Alerts, c.Alerts) {
+		names = append(names, "alerts")
+	}
+	if old.Log.Format != c.Log.Format {
+		names = append(names, "log.format")
+	}
+	return names
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// 分布在同一网段内多个地址上的泛洪
+const attackSubnetFlood attackType = "subnet_flood"
+
+// 一条网段聚合规则, 例如IPv4按/24、IPv6按/64聚合
+type aggregateConfig struct {
+	Family              string  `yaml:"family"` // ipv4 或 ipv6
+	PrefixLen           int     `yaml:"prefix_len"`
+	MaxPacketsPerSecond float64 `yaml:"max_packets_per_second"`
+}
+
+func (a aggregateConfig) matches(addr netip.Addr) bool {
+	if a.Family == "ipv4" {
+		return addr.Is4()
+	}
+	return addr.Is6()
+}
+
+// 阻塞目标中出现过的网段长度, 用于判断某个地址是否落在被阻塞的网段内
+type prefixLen struct {
+	is4  bool
+	bits int
+}
+
+var blockedPrefixLens = make(map[prefixLen]int)
+
+// 对源地址所在的各级网段计数, 聚合速率超限时阻塞整个网段, 调用时需持有s.mu
+func (s *shard) observePrefixesLocked(cfg *config, info *packetInfo, now time.Time) {
+	addr := info.srcIP
+	for _, agg := range cfg.Aggregates {
+		if !agg.matches(addr) {
+			continue
+		}
+		prefix, err := addr.Prefix(agg.PrefixLen)
+		if err != nil {
+			continue
+		}
+
+		window, ok := s.prefixCounters.Get(prefix, now)
+		if !ok {
+			window = newRateWindow(cfg)
+			s.prefixCounters.Put(prefix, window, now)
+		}
+		window.Add(now, 1)
+
+		rate := window.Rate(now)
+		if rate <= agg.MaxPacketsPerSecond {
+			continue
+		}
+		// 网段中有可信来源时不能整体阻塞, 只依靠单个IP的检测
+		if trusted.Overlaps(prefix.String()) {
+			continue
+		}
+		mu.Lock()
+		blockLocked(cfg, prefix.String(), attackSubnetFlood, rate, info.iface, now)
+		mu.Unlock()
+		s.prefixCounters.Delete(prefix)
+		return
+	}
+}
+
+// 查找覆盖该地址的阻塞记录: 先查来源键(IPv4地址或IPv6前缀), 再查各个被阻塞的网段
+func findBlockLocked(addr netip.Addr, key string) (string, *blockEntry, bool) {
+	if entry, ok := blockedIPs[key]; ok {
+		return key, entry, true
+	}
+	target := addr.String()
+	if entry, ok := blockedIPs[target]; ok {
+		return target, entry, true
+	}
+	for pl := range blockedPrefixLens {
+		if pl.is4 != addr.Is4() {
+			continue
+		}
+		prefix, err := addr.Prefix(pl.bits)
+		if err != nil {
+			continue
+		}
+		target = prefix.String()
+		if entry, ok := blockedIPs[target]; ok {
+			return target, entry, true
+		}
+	}
+	return "", nil, false
+}
+
+// 记录或删除阻塞列表中的一项, 同时维护网段长度的引用计数, 调用时需持有mu
+func setBlockLocked(entry *blockEntry) {
+	if _, exists := blockedIPs[entry.Target]; !exists {
+		trackPrefixLenLocked(entry.Target, 1)
+	}
+	blockedIPs[entry.Target] = entry
+}
+
+func deleteBlockLocked(target string) {
+	if _, exists := blockedIPs[target]; !exists {
+		return
+	}
+	delete(blockedIPs, target)
+	trackPrefixLenLocked(target, -1)
+}
+
+func trackPrefixLenLocked(target string, delta int) {
+	if !strings.Contains(target, "/") {
+		return
+	}
+	prefix, err := netip.ParsePrefix(target)
+	if err != nil {
+		return
+	}
+	pl := prefixLen{is4: prefix.Addr().Is4(), bits: prefix.Bits()}
+	blockedPrefixLens[pl] += delta
+	if blockedPrefixLens[pl] <= 0 {
+		delete(blockedPrefixLens, pl)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"container/list"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// 有容量上限的LRU表, 用于保存每个来源和网段的统计
+//
+// 伪造源地址的泛洪会产生海量不同的来源, 普通map会无限增长并耗尽内存。
+// 表满时淘汰最久没有数据包的条目, 后台清理器定期删除长时间空闲的条目。
+// 不是并发安全的, 由调用方加锁(每个分片各有一组表, 见pipeline.go)。
+type lruTable[K comparable, V any] struct {
+	max   int
+	items map[K]*list.Element
+	order *list.List // 头部是最近使用的条目
+}
+
+type lruItem[K comparable, V any] struct {
+	key      K
+	value    V
+	lastSeen time.Time
+}
+
+// 因容量或空闲被淘汰的统计条目数
+var trackerEvictions atomic.Uint64
+
+func newLRUTable[K comparable, V any](max int) *lruTable[K, V] {
+	return &lruTable[K, V]{
+		max:   max,
+		items: make(map[K]*list.Element),
+		order: list.New(),
+	}
+}
+
+// 查找条目并标记为最近使用
+func (t *lruTable[K, V]) Get(key K, now time.Time) (V, bool) {
+	elem, ok := t.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	item := elem.Value.(*lruItem[K, V])
+	item.lastSeen = now
+	t.order.MoveToFront(elem)
+	return item.value, true
+}
+
+// 插入新条目, 超过容量时淘汰最久未使用的条目
+func (t *lruTable[K, V]) Put(key K, value V, now time.Time) {
+	if elem, ok := t.items[key]; ok {
+		item := elem.Value.(*lruItem[K, V])
+		item.value = value
+		item.lastSeen = now
+		t.order.MoveToFront(elem)
+		return
+	}
+	t.items[key] = t.order.PushFront(&lruItem[K, V]{key: key, value: value, lastSeen: now})
+	t.trim()
+}
+
+func (t *lruTable[K, V]) Delete(key K) {
+	if elem, ok := t.items[key]; ok {
+		t.order.Remove(elem)
+		delete(t.items, key)
+	}
+}
+
+func (t *lruTable[K, V]) Len() int {
+	return len(t.items)
+}
+
+// 按最近使用的顺序遍历所有条目, 不改变顺序
+func (t *lruTable[K, V]) Each(fn func(key K, value V, lastSeen time.Time)) {
+	for elem := t.order.Front(); elem != nil; elem = elem.Next() {
+		item := elem.Value.(*lruItem[K, V])
+		fn(item.key, item.value, item.lastSeen)
+	}
+}
+
+// 修改容量上限, 缩小时立即淘汰多出的条目
+func (t *lruTable[K, V]) SetMax(max int) {
+	t.max = max
+	t.trim()
+}
+
+// 删除在 before 之前就不再活跃的条目, 返回删除的数量
+func (t *lruTable[K, V]) EvictIdle(before time.Time) int {
+	evicted := 0
+	for elem := t.order.Back(); elem != nil; elem = t.order.Back() {
+		item := elem.Value.(*lruItem[K, V])
+		if !item.lastSeen.Before(before) {
+			break // 其余条目都更新
+		}
+		t.order.Remove(elem)
+		delete(t.items, item.key)
+		evicted++
+	}
+	trackerEvictions.Add(uint64(evicted))
+	return evicted
+}
+
+func (t *lruTable[K, V]) trim() {
+	for t.max > 0 && len(t.items) > t.max {
+		elem := t.order.Back()
+		t.order.Remove(elem)
+		delete(t.items, elem.Value.(*lruItem[K, V]).key)
+		trackerEvictions.Add(1)
+	}
+}
+
+// 按配置设置统计表的容量上限, 总容量平均分给各个分片
+func (p *pipeline) applyTrackingLimits(cfg *config) {
+	n := len(p.shards)
+	p.eachShard(func(s *shard) {
+		s.ipCounters.SetMax((cfg.Tracking.MaxSources + n - 1) / n)
+		s.prefixCounters.SetMax((cfg.Tracking.MaxPrefixes + n - 1) / n)
+	})
+}
+
+// 定期清理空闲的统计条目
+func sweepTrackers() {
+	for {
+		cfg := currentConfig()
+		time.Sleep(cfg.Tracking.SweepInterval)
+		sweepTrackersAt(cfg, time.Now())
+	}
+}
+
+// 删除 now 之前 idle_timeout 内没有数据包的条目
+func sweepTrackersAt(cfg *config, now time.Time) {
+	before := now.Add(-cfg.Tracking.IdleTimeout)
+
+	evicted, sources, prefixes := 0, 0, 0
+	engine.eachShard(func(s *shard) {
+		evicted += s.ipCounters.EvictIdle(before) + s.prefixCounters.EvictIdle(before)
+		sources += s.ipCounters.Len()
+		prefixes += s.prefixCounters.Len()
+	})
+
+	if evicted > 0 {
+		logEvent(slog.LevelDebug, "trackers_swept", "已清理空闲统计条目",
+			slog.Int("evicted", evicted), slog.Int("sources", sources), slog.Int("prefixes", prefixes),
+			slog.Uint64("evicted_total", trackerEvictions.Load()))
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+)
+
+func lruKeys(t *lruTable[string, int]) []string {
+	var keys []string
+	t.Each(func(key string, _ int, _ time.Time) { keys = append(keys, key) })
+	return keys
+}
+
+func TestLRUTableEvictsLeastRecentlyUsed(t *testing.T) {
+	t0 := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	table := newLRUTable[string, int](3)
+	start := trackerEvictions.Load()
+
+	table.Put("a", 1, t0)
+	table.Put("b", 2, t0.Add(time.Second))
+	table.Put("c", 3, t0.Add(2*time.Second))
+	// Get 把 a 标记为最近使用, 下一次插入淘汰 b
+	if v, ok := table.Get("a", t0.Add(3*time.Second)); !ok || v != 1 {
+		t.Fatalf("Get(a) = %d, %v", v, ok)
+	}
+	table.Put("d", 4, t0.Add(4*time.Second))
+	if got, want := lruKeys(table), []string{"d", "a", "c"}; !slices.Equal(got, want) {
+		t.Fatalf("条目 %v, 期望 %v", got, want)
+	}
+	if _, ok := table.Get("b", t0.Add(5*time.Second)); ok {
+		t.Fatal("b 应该被淘汰")
+	}
+
+	// 更新已有的条目不淘汰其他条目
+	table.Put("c", 30, t0.Add(6*time.Second))
+	if got, want := lruKeys(table), []string{"c", "d", "a"}; !slices.Equal(got, want) {
+		t.Fatalf("条目 %v, 期望 %v", got, want)
+	}
+	if v, _ := table.Get("c", t0.Add(6*time.Second)); v != 30 {
+		t.Fatalf("Get(c) = %d, 期望30", v)
+	}
+
+	// 缩小容量时立即淘汰多出的条目
+	table.SetMax(1)
+	if got, want := lruKeys(table), []string{"c"}; !slices.Equal(got, want) {
+		t.Fatalf("条目 %v, 期望 %v", got, want)
+	}
+	if n := trackerEvictions.Load() - start; n != 3 {
+		t.Errorf("trackerEvictions 增加了 %d, 期望3", n)
+	}
+}
+
+func TestLRUTableEvictIdle(t *testing.T) {
+	t0 := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	table := newLRUTable[string, int](0) // 0表示不限制容量
+	for i, key := range []string{"a", "b", "c", "d"} {
+		table.Put(key, i, t0.Add(time.Duration(i)*time.Minute))
+	}
+	// a 最早插入, 但最近有数据包
+	table.Get("a", t0.Add(10*time.Minute))
+	start := trackerEvictions.Load()
+
+	// 正好在截止时间活跃的条目保留
+	if n := table.EvictIdle(t0.Add(2 * time.Minute)); n != 1 {
+		t.Fatalf("删除了 %d 个条目, 期望1", n)
+	}
+	if got, want := lruKeys(table), []string{"a", "d", "c"}; !slices.Equal(got, want) {
+		t.Fatalf("条目 %v, 期望 %v", got, want)
+	}
+	if n := table.EvictIdle(t0.Add(2 * time.Minute)); n != 0 {
+		t.Fatalf("再次清理删除了 %d 个条目", n)
+	}
+	if n := trackerEvictions.Load() - start; n != 1 {
+		t.Errorf("trackerEvictions 增加了 %d, 期望1", n)
+	}
+
+	// 手动删除不计入淘汰
+	table.Delete("d")
+	if table.Len() != 2 || trackerEvictions.Load()-start != 1 {
+		t.Fatalf("Len = %d, 期望2", table.Len())
+	}
+}
+
+func TestSweepTrackers(t *testing.T) {
+	t0 := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	cfg := defaultConfig()
+	cfg.Tracking.IdleTimeout = 2 * time.Minute
+
+	old := engine
+	engine = newPipeline(2, 16)
+	t.Cleanup(func() {
+		engine.Close()
+		engine = old
+	})
+	engine.applyTrackingLimits(cfg)
+
+	prefixes := []netip.Prefix{netip.MustParsePrefix("198.51.100.0/24"), netip.MustParsePrefix("2001:db8::/48")}
+	for i, s := range engine.shards {
+		s.ipCounters.Put("203.0.113.1", nil, t0)
+		s.ipCounters.Put("203.0.113.2", nil, t0.Add(2*time.Minute))
+		s.prefixCounters.Put(prefixes[i], nil, t0.Add(30*time.Second))
+	}
+	start := trackerEvictions.Load()
+
+	sweepTrackersAt(cfg, t0.Add(3*time.Minute))
+	if sources, prefixes := engine.trackedCounts(); sources != 2 || prefixes != 0 {
+		t.Fatalf("清理后跟踪 %d 个来源, %d 个网段, 期望2和0", sources, prefixes)
+	}
+	if n := trackerEvictions.Load() - start; n != 4 {
+		t.Errorf("trackerEvictions 增加了 %d, 期望4", n)
+	}
+}